@@ -0,0 +1,154 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"bytes"
+	"crypto/hmac"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 默认的签名请求头，和通用webhook后端发送时使用的请求头一致
+const defaultHMACHeader = "X-Alert-Gateway-Signature"
+
+// 接收报警的认证，先检查来源地址，再检查凭据
+type Authenticator struct {
+	auth     config.Auth
+	networks []*net.IPNet
+}
+
+func NewAuthenticator(auth config.Auth) (*Authenticator, error) {
+	a := &Authenticator{auth: auth}
+	if a.auth.HMACHeader == "" {
+		a.auth.HMACHeader = defaultHMACHeader
+	}
+	if (auth.Username == "") != (auth.Password == "") {
+		return nil, fmt.Errorf("auth: username and password must be provided together")
+	}
+	for _, cidr := range auth.AllowedCIDRs {
+		// 单个地址按 /32 或 /128 处理
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("auth: invalid allowed_cidrs %q: %w", cidr, err)
+		}
+		a.networks = append(a.networks, network)
+	}
+	return a, nil
+}
+
+// 是否配置了凭据
+func (a *Authenticator) requireCredentials() bool {
+	return a.auth.BearerToken != "" || a.auth.Username != "" || a.auth.HMACSecret != ""
+}
+
+// 检查来源地址是否在允许的网段中，没有配置网段时都允许
+func (a *Authenticator) allowed(remoteAddr string) bool {
+	if len(a.networks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查请求的凭据，满足任意一种配置的认证方式即可，body 用于校验签名
+func (a *Authenticator) authenticated(r *http.Request, body []byte) bool {
+	if !a.requireCredentials() {
+		return true
+	}
+	if a.auth.BearerToken != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(token, string(a.auth.BearerToken)) {
+			return true
+		}
+	}
+	if a.auth.Username != "" {
+		if username, password, ok := r.BasicAuth(); ok &&
+			secureEqual(username, a.auth.Username) && secureEqual(password, string(a.auth.Password)) {
+			return true
+		}
+	}
+	if a.auth.HMACSecret != "" {
+		// 兼容 sha256=<hex> 格式的签名
+		signature := strings.TrimPrefix(r.Header.Get(a.auth.HMACHeader), "sha256=")
+		expected := makeWebhookSign(body, string(a.auth.HMACSecret))
+		if signature != "" && hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+// 用固定时间比较字符串，避免通过响应时间猜测凭据
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// 认证中间件，来源地址不在允许的网段中返回 403，凭据错误返回 401
+// 校验签名需要读取请求体，读取后重新放回请求中供后面的处理函数使用
+func (a *Authenticator) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.allowed(r.RemoteAddr) {
+			logger.Errorf("Rejected request to %s from %s: address not allowed", r.URL.Path, r.RemoteAddr)
+			authRejected.WithLabelValues("forbidden").Inc()
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !a.requireCredentials() {
+			next(w, r)
+			return
+		}
+
+		var body []byte
+		if a.auth.HMACSecret != "" {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				logger.Errorf("Failed to read request body: %v", err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if !a.authenticated(r, body) {
+			logger.Errorf("Rejected request to %s from %s: unauthorized", r.URL.Path, r.RemoteAddr)
+			authRejected.WithLabelValues("unauthorized").Inc()
+			if a.auth.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="alert_gateway"`)
+			} else if a.auth.BearerToken != "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// 使用当前配置中的认证检查请求，重新加载配置后立即使用新的认证配置
+func (app *App) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.current().auth.Wrap(next)(w, r)
+	}
+}
@@ -0,0 +1,385 @@
+package apps
+
+import (
+	"alert_gateway/logger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求体的解码器，把不同来源的报警转换为统一的 AlertData
+type Decoder interface {
+	// 解码器的名称，POST /webhook/<name> 按名称选择解码器
+	Name() string
+	// 请求体是否是这种格式，没有指定解码器时按顺序选择第一个匹配的解码器
+	Match(body []byte) bool
+	Decode(body []byte) (AlertData, error)
+}
+
+// 支持的解码器，按匹配的顺序排列，plain 匹配所有的 json，放在最后
+var decoders = []Decoder{
+	grafanaDecoder{},
+	alertmanagerDecoder{},
+	alertmanagerV2Decoder{},
+	plainDecoder{},
+}
+
+// 按名称查找解码器，找不到时返回 nil
+func decoderByName(name string) Decoder {
+	for _, decoder := range decoders {
+		if decoder.Name() == name {
+			return decoder
+		}
+	}
+	return nil
+}
+
+// 按请求的路径选择解码器的名称，/webhook/<name> 为指定的解码器，/api/v2/alerts 为 alertmanager-v2
+// 其他路径返回空字符串，根据请求体判断格式
+func decoderName(path string) string {
+	if path == "/api/v2/alerts" {
+		return "alertmanager-v2"
+	}
+	if name, ok := strings.CutPrefix(path, "/webhook/"); ok {
+		return strings.Trim(name, "/")
+	}
+	return ""
+}
+
+// 解码报警，name 为空时根据请求体判断格式
+func DecodeAlerts(name string, body []byte) (AlertData, string, error) {
+	var decoder Decoder
+	if name != "" {
+		if decoder = decoderByName(name); decoder == nil {
+			return AlertData{}, "", fmt.Errorf("unknown decoder %q", name)
+		}
+	} else {
+		decoder = sniffDecoder(body)
+	}
+	alertData, err := decoder.Decode(body)
+	if err != nil {
+		return AlertData{}, decoder.Name(), err
+	}
+	normalize(&alertData)
+	return alertData, decoder.Name(), nil
+}
+
+func sniffDecoder(body []byte) Decoder {
+	for _, decoder := range decoders {
+		if decoder.Match(body) {
+			return decoder
+		}
+	}
+	return plainDecoder{}
+}
+
+// 补全不同来源缺少的字段：报警的状态、开始时间，整条通知的状态、共同的标签和注释
+func normalize(alertData *AlertData) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	firing := false
+	for i := range alertData.Alerts {
+		alert := &alertData.Alerts[i]
+		if alert.Status != "resolved" {
+			alert.Status = "firing"
+			firing = true
+		}
+		if alert.Labels == nil {
+			alert.Labels = make(map[string]string)
+		}
+		if alert.Annotations == nil {
+			alert.Annotations = make(map[string]string)
+		}
+		if alert.StartsAt == "" {
+			alert.StartsAt = now
+		}
+	}
+	if alertData.Status == "" {
+		alertData.Status = "resolved"
+		if firing {
+			alertData.Status = "firing"
+		}
+	}
+	if alertData.CommonLabels == nil {
+		alertData.CommonLabels = commonPairs(alertData.Alerts, func(alert Alert) map[string]string { return alert.Labels })
+	}
+	if alertData.CommonAnnotations == nil {
+		alertData.CommonAnnotations = commonPairs(alertData.Alerts, func(alert Alert) map[string]string { return alert.Annotations })
+	}
+}
+
+// 所有报警中名称和值都相同的标签或注释
+func commonPairs(alerts []Alert, pairs func(Alert) map[string]string) map[string]string {
+	common := make(map[string]string)
+	if len(alerts) == 0 {
+		return common
+	}
+	for name, value := range pairs(alerts[0]) {
+		common[name] = value
+	}
+	for _, alert := range alerts[1:] {
+		other := pairs(alert)
+		for name, value := range common {
+			if v, ok := other[name]; !ok || v != value {
+				delete(common, name)
+			}
+		}
+	}
+	return common
+}
+
+// 请求体为 json 对象时返回顶层的字段
+func jsonObject(body []byte) map[string]json.RawMessage {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(body, &object) != nil {
+		return nil
+	}
+	return object
+}
+
+// alertmanager 的 webhook，格式为 version 4
+type alertmanagerDecoder struct{}
+
+func (alertmanagerDecoder) Name() string { return "alertmanager" }
+
+func (alertmanagerDecoder) Match(body []byte) bool {
+	_, ok := jsonObject(body)["alerts"]
+	return ok
+}
+
+func (alertmanagerDecoder) Decode(body []byte) (AlertData, error) {
+	var alertData AlertData
+	err := json.Unmarshal(body, &alertData)
+	return alertData, err
+}
+
+// grafana 统一告警的 webhook，在 alertmanager 格式的基础上增加了 orgId、title、state、message 和报警的面板、取值等字段
+type grafanaDecoder struct{}
+
+func (grafanaDecoder) Name() string { return "grafana" }
+
+func (grafanaDecoder) Match(body []byte) bool {
+	object := jsonObject(body)
+	_, alerts := object["alerts"]
+	_, orgID := object["orgId"]
+	return alerts && orgID
+}
+
+func (grafanaDecoder) Decode(body []byte) (AlertData, error) {
+	var alertData AlertData
+	if err := json.Unmarshal(body, &alertData); err != nil {
+		return alertData, err
+	}
+	// grafana 的报警没有 alertname 标签时使用规则的标题
+	for i := range alertData.Alerts {
+		alert := &alertData.Alerts[i]
+		if alert.Labels["alertname"] == "" && alertData.Title != "" {
+			if alert.Labels == nil {
+				alert.Labels = make(map[string]string)
+			}
+			alert.Labels["alertname"] = alertData.Title
+		}
+	}
+	return alertData, nil
+}
+
+// alertmanager v2 API 的报警列表，和 prometheus 推送给 alertmanager 的格式相同，POST /api/v2/alerts
+// 没有状态字段，endsAt 早于当前时间的报警为已恢复
+type alertmanagerV2Decoder struct{}
+
+func (alertmanagerV2Decoder) Name() string { return "alertmanager-v2" }
+
+func (alertmanagerV2Decoder) Match(body []byte) bool {
+	var alerts []map[string]json.RawMessage
+	if json.Unmarshal(body, &alerts) != nil || len(alerts) == 0 {
+		return false
+	}
+	_, ok := alerts[0]["labels"]
+	return ok
+}
+
+func (alertmanagerV2Decoder) Decode(body []byte) (AlertData, error) {
+	var alerts []Alert
+	if err := json.Unmarshal(body, &alerts); err != nil {
+		return AlertData{}, err
+	}
+	now := time.Now()
+	for i := range alerts {
+		alert := &alerts[i]
+		if len(alert.Labels) == 0 {
+			return AlertData{}, fmt.Errorf("alerts[%d]: labels must be provided", i)
+		}
+		alert.Status = "firing"
+		if endsAt, err := time.Parse(time.RFC3339, alert.EndsAt); err == nil && !endsAt.After(now) {
+			alert.Status = "resolved"
+		}
+	}
+	return AlertData{Alerts: alerts, Version: "v2"}, nil
+}
+
+// 脚本发送的简单报警，可以是一个对象或者对象的列表
+// {"alertname": "backup_failed", "severity": "critical", "instance": "db01", "summary": "备份失败"}
+type plainAlert struct {
+	Alertname   string            `json:"alertname"`
+	Status      string            `json:"status"`
+	Severity    string            `json:"severity"`
+	Instance    string            `json:"instance"`
+	Summary     string            `json:"summary"`
+	Description string            `json:"description"`
+	URL         string            `json:"url"`
+	StartsAt    string            `json:"startsAt"`
+	EndsAt      string            `json:"endsAt"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type plainDecoder struct{}
+
+func (plainDecoder) Name() string { return "plain" }
+
+func (plainDecoder) Match(body []byte) bool {
+	return json.Valid(body)
+}
+
+func (plainDecoder) Decode(body []byte) (AlertData, error) {
+	var plains []plainAlert
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(body, &plains); err != nil {
+			return AlertData{}, err
+		}
+	} else {
+		var plain plainAlert
+		if err := json.Unmarshal(body, &plain); err != nil {
+			return AlertData{}, err
+		}
+		plains = append(plains, plain)
+	}
+	if len(plains) == 0 {
+		return AlertData{}, errors.New("no alerts")
+	}
+
+	alertData := AlertData{Version: "plain"}
+	for i, plain := range plains {
+		alert := Alert{
+			Status:       plain.Status,
+			Labels:       make(map[string]string),
+			Annotations:  make(map[string]string),
+			StartsAt:     plain.StartsAt,
+			EndsAt:       plain.EndsAt,
+			GeneratorURL: plain.URL,
+		}
+		if alert.Status == "ok" {
+			alert.Status = "resolved"
+		}
+		for name, value := range plain.Labels {
+			alert.Labels[name] = value
+		}
+		for name, value := range map[string]string{"alertname": plain.Alertname, "severity": plain.Severity, "instance": plain.Instance} {
+			if value != "" {
+				alert.Labels[name] = value
+			}
+		}
+		for name, value := range plain.Annotations {
+			alert.Annotations[name] = value
+		}
+		for name, value := range map[string]string{"summary": plain.Summary, "description": plain.Description} {
+			if value != "" {
+				alert.Annotations[name] = value
+			}
+		}
+		if alert.Labels["alertname"] == "" {
+			return AlertData{}, fmt.Errorf("alerts[%d]: alertname must be provided", i)
+		}
+		alertData.Alerts = append(alertData.Alerts, alert)
+	}
+	return alertData, nil
+}
+
+// alertmanager v2 API 推送的报警最后发送的状态
+// prometheus 每隔一分钟左右重新推送所有正在触发的报警，恢复的报警会继续推送 15 分钟，
+// 直接推送到网关时状态没有变化的报警不再发送，一小时没有再收到的记录被清除
+type StatusTracker struct {
+	mu        sync.Mutex
+	entries   map[string]statusEntry
+	lastSweep time.Time
+}
+
+type statusEntry struct {
+	status string
+	seen   time.Time
+}
+
+const statusTrackerTTL = time.Hour
+
+func NewStatusTracker() *StatusTracker {
+	return &StatusTracker{entries: make(map[string]statusEntry), lastSweep: time.Now()}
+}
+
+// 返回状态和上次发送时不同的报警，并记录新的状态
+func (t *StatusTracker) Changed(alerts []Alert) []Alert {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.lastSweep) > statusTrackerTTL {
+		for fingerprint, entry := range t.entries {
+			if now.Sub(entry.seen) > statusTrackerTTL {
+				delete(t.entries, fingerprint)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	var changed []Alert
+	for _, alert := range alerts {
+		fingerprint := alertFingerprint(alert)
+		entry, ok := t.entries[fingerprint]
+		t.entries[fingerprint] = statusEntry{status: alert.Status, seen: now}
+		if !ok || entry.status != alert.Status {
+			changed = append(changed, alert)
+		}
+	}
+	return changed
+}
+
+// 报警没有发送出去时删除记录，下次推送时重新发送
+func (t *StatusTracker) Forget(alert Alert) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, alertFingerprint(alert))
+}
+
+// 只保留状态发生变化的 v2 报警，重新计算整条通知的状态和共同的标签
+// 重复推送的源报警带着新的 endsAt，去掉之前先交给抑制器延长抑制的时间
+func (app *App) dropRepeated(alertData *AlertData) {
+	if inhibitor := app.current().inhibitor; inhibitor != nil {
+		for _, alert := range alertData.Alerts {
+			inhibitor.Observe(alert)
+		}
+	}
+	alerts := app.v2Status.Changed(alertData.Alerts)
+	if skipped := len(alertData.Alerts) - len(alerts); skipped > 0 {
+		logger.Debugf("Skipped %d alerts without status change", skipped)
+	}
+	alertData.Alerts = alerts
+	alertData.Status = ""
+	alertData.CommonLabels = nil
+	alertData.CommonAnnotations = nil
+	normalize(alertData)
+}
+
+// 被抑制或者发送失败的 v2 报警删除状态记录，下次推送时重新判断
+func (app *App) forgetUndelivered(alerts []Alert, responses []map[string]interface{}) {
+	for i, deliveries := range historyDeliveries(responses) {
+		delivered := len(deliveries) > 0
+		for _, delivery := range deliveries {
+			if delivery.Result == "failed" || delivery.Result == "dead" || delivery.Result == "suppressed" {
+				delivered = false
+			}
+		}
+		if !delivered {
+			app.v2Status.Forget(alerts[i])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package apps
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 按指纹对报警去重
+// alertmanager 每隔 repeat_interval 会重新发送仍在触发的报警，多个 alertmanager 组成高可用集群时每个都会发送一份，
+// 同一个报警的故障通知在 ttl 时间内只发送一次，恢复通知总是发送并清除记录
+type Deduplicator struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]time.Time // 指纹对应的过期时间
+	lastSweep time.Time
+	dropped   uint64
+}
+
+func NewDeduplicator(ttl time.Duration) *Deduplicator {
+	return &Deduplicator{
+		ttl:       ttl,
+		entries:   make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// 修改去重时间，已有的记录保持原来的过期时间
+func (d *Deduplicator) SetTTL(ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ttl = ttl
+}
+
+// 判断是否需要发送报警，需要发送时在同一个锁内记录，并发收到的同一个报警只有一个可以发送
+// ttl 时间内已经发送过的故障通知返回 false，其他故障通知开始一个新的去重窗口，恢复通知总是发送并清除记录
+func (d *Deduplicator) Reserve(alert Alert) bool {
+	fingerprint := alertFingerprint(alert)
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+	if alert.Status == "resolved" {
+		delete(d.entries, fingerprint)
+		return true
+	}
+	if expires, ok := d.entries[fingerprint]; ok && now.Before(expires) {
+		d.dropped++
+		return false
+	}
+	d.entries[fingerprint] = now.Add(d.ttl)
+	return true
+}
+
+// 发送失败时删除 Reserve 的记录，alertmanager 重新推送时可以再次发送
+func (d *Deduplicator) Release(alert Alert) {
+	if alert.Status == "resolved" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, alertFingerprint(alert))
+}
+
+// 定期清理过期的记录，调用时需要持有锁
+func (d *Deduplicator) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) <= d.ttl {
+		return
+	}
+	for key, expires := range d.entries {
+		if !now.Before(expires) {
+			delete(d.entries, key)
+		}
+	}
+	d.lastSweep = now
+}
+
+// 被去重丢弃的通知数量
+func (d *Deduplicator) Dropped() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dropped
+}
+
+// 报警的指纹，alertmanager 发送的数据中有 fingerprint 字段，没有时按标签计算
+func alertFingerprint(alert Alert) string {
+	if alert.Fingerprint != "" {
+		return alert.Fingerprint
+	}
+	names := make([]string, 0, len(alert.Labels))
+	for name := range alert.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(alert.Labels[name]))
+		h.Write([]byte{0xff})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
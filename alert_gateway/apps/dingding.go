@@ -3,124 +3,310 @@ package apps
 import (
 	"alert_gateway/config"
 	"alert_gateway/logger"
-	"errors"
-	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 )
 
-func sendMsg(message, messageType string, cfg config.Config) (string, error) {
-	dingdingUrl, err := checkConfig(cfg)
+// 钉钉Markdown消息结构
+type MarkdownMessage struct {
+	MsgType  string      `json:"msgtype"`
+	Markdown Markdown    `json:"markdown"`
+	At       *DingTalkAt `json:"at,omitempty"`
+}
+
+// 钉钉文本消息结构
+type TextMessage struct {
+	MsgType string      `json:"msgtype"`
+	Text    Text        `json:"text"`
+	At      *DingTalkAt `json:"at,omitempty"`
+}
+
+// 钉钉ActionCard消息结构
+type ActionCardMessage struct {
+	MsgType    string     `json:"msgtype"`
+	ActionCard ActionCard `json:"actionCard"`
+}
+
+// ActionCard内容结构，btnOrientation 为 0 时按钮竖直排列
+type ActionCard struct {
+	Title          string             `json:"title"`
+	Text           string             `json:"text"`
+	BtnOrientation string             `json:"btnOrientation"`
+	Btns           []ActionCardButton `json:"btns"`
+}
+
+// ActionCard中的按钮
+type ActionCardButton struct {
+	Title     string `json:"title"`
+	ActionURL string `json:"actionURL"`
+}
+
+// 钉钉FeedCard消息结构
+type FeedCardMessage struct {
+	MsgType  string   `json:"msgtype"`
+	FeedCard FeedCard `json:"feedCard"`
+}
+
+// FeedCard内容结构
+type FeedCard struct {
+	Links []FeedCardLink `json:"links"`
+}
+
+// FeedCard中的链接
+type FeedCardLink struct {
+	Title      string `json:"title"`
+	MessageURL string `json:"messageURL"`
+	PicURL     string `json:"picURL"`
+}
+
+// 钉钉消息中@的人
+type DingTalkAt struct {
+	AtMobiles []string `json:"atMobiles,omitempty"`
+	AtUserIds []string `json:"atUserIds,omitempty"`
+	IsAtAll   bool     `json:"isAtAll,omitempty"`
+}
+
+// Markdown内容结构
+type Markdown struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+	Theme string `json:"theme"`
+}
+
+// 文本内容结构
+type Text struct {
+	Content string `json:"content"`
+}
+
+// 钉钉机器人通知后端
+type DingTalk struct {
+	receiverBase
+	// 值班表，用于@当前的值班人员
+	schedules Schedules
+}
+
+func newDingTalk(receiver config.Receiver) (*DingTalk, error) {
+	if receiver.WebhookURL == "" {
+		receiver.WebhookURL = "https://oapi.dingtalk.com/robot/send?"
+	}
+	if receiver.Token == "" {
+		return nil, fmt.Errorf("receiver %s: token must be provided", receiver.Name)
+	}
+	return &DingTalk{receiverBase: receiverBase{receiver: receiver}}, nil
+}
+
+func (d *DingTalk) Send(msg *Message) (*Result, error) {
+	dingdingUrl, err := d.webhookURL()
+	if err != nil {
+		logger.Errorf("get dingding webhook fail: %v", err)
+		return nil, err
+	}
+
+	logger.Debugf("ding ding webhook url: %saccess_token=%s", d.receiver.WebhookURL, d.receiver.Token)
+
+	sendDataBytes, err := d.marshal(msg)
 	if err != nil {
-		logger.Errorf("get dingding webhook fail: %s", dingdingUrl)
-		return "", err
+		logger.Errorf("Failed to marshal JSON: %v", err)
+		return nil, err
+	}
+	logger.Debug(string(sendDataBytes))
+
+	start := time.Now()
+	statusCode, respBody, err := postJSON(dingdingUrl, sendDataBytes, nil)
+	dingdingRequestDuration.WithLabelValues(d.Name()).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("发送钉钉相应 %s", respBody)
+
+	// 处理响应，钉钉在响应体的 errcode 中返回错误
+	result := &Result{Receiver: d.Name(), StatusCode: statusCode, RespMsg: respBody}
+	if statusCode != http.StatusOK {
+		logger.Errorf("Failed to send message, status code: %d, response: %s", statusCode, respBody)
+		return result, &HTTPStatusError{StatusCode: statusCode, Body: respBody}
+	}
+	var resp DingTalkResponse
+	if err := json.Unmarshal([]byte(respBody), &resp); err != nil {
+		return result, fmt.Errorf("decode dingding response %q: %w", respBody, err)
 	}
+	result.ErrCode = resp.ErrCode
+	result.ErrMsg = resp.ErrMsg
+	if resp.ErrCode != 0 {
+		return result, &DingTalkError{Code: resp.ErrCode, Msg: resp.ErrMsg}
+	}
+	return result, nil
+}
 
-	logger.Debugf("ding ding webhook url: %s", dingdingUrl)
+// 按消息类型生成钉钉的消息
+// ActionCard 没有按钮、FeedCard 有消息没有链接时改为 markdown 消息发送
+func (d *DingTalk) marshal(msg *Message) ([]byte, error) {
+	switch msg.Type {
+	case "actionCard":
+		if btns := actionButtons(msg); len(btns) > 0 {
+			return json.Marshal(ActionCardMessage{
+				MsgType: "actionCard",
+				ActionCard: ActionCard{
+					Title:          msg.Title,
+					Text:           d.withKeyword(msg.Content),
+					BtnOrientation: "0",
+					Btns:           btns,
+				},
+			})
+		}
+	case "feedCard":
+		if links, ok := feedLinks(msg); ok {
+			// 机器人配置了关键词时，标题中包含关键词才能发送成功
+			links[0].Title = d.withKeyword(links[0].Title)
+			return json.Marshal(FeedCardMessage{
+				MsgType:  "feedCard",
+				FeedCard: FeedCard{Links: links},
+			})
+		}
+	}
 
-	var sendDataBytes []byte
-	//var err error
+	at := d.mentions(msg)
+	content := d.withMentions(d.withKeyword(msg.Content), at)
 	// 判断是文本消息还是markdown消息
-	if messageType == "text" {
-		sendData := TextMessage{
+	if msg.Type == "text" {
+		return json.Marshal(TextMessage{
 			MsgType: "text",
 			Text: Text{
-				Content: message,
+				Content: content,
 			},
+			At: at,
+		})
+	}
+	return json.Marshal(MarkdownMessage{
+		MsgType: "markdown",
+		Markdown: Markdown{
+			Title: "消息",
+			Text:  content,
+			Theme: "white",
+		},
+		At: at,
+	})
+}
+
+// ActionCard 的按钮：报警的来源、runbook_url 注解、grafana 的面板和静默页面
+// 来源和 runbook 取第一个有值的报警，静默页面按所有报警共同的标签过滤
+func actionButtons(msg *Message) []ActionCardButton {
+	var btns []ActionCardButton
+	for _, alert := range msg.Alerts {
+		if alert.GeneratorURL != "" {
+			btns = append(btns, ActionCardButton{Title: "查看来源", ActionURL: alert.GeneratorURL})
+			break
 		}
-		sendDataBytes, err = json.Marshal(sendData)
-	} else {
-		sendData := MarkdownMessage{
-			MsgType: "markdown",
-			Markdown: Markdown{
-				Title: "消息",
-				Text:  message,
-				Theme: "white",
-			},
+	}
+	for _, alert := range msg.Alerts {
+		if runbook := alert.Annotations["runbook_url"]; runbook != "" {
+			btns = append(btns, ActionCardButton{Title: "处理手册", ActionURL: runbook})
+			break
 		}
-		sendDataBytes, err = json.Marshal(sendData)
 	}
-
-	if err != nil {
-		logger.Errorf("Failed to marshal JSON: %v", err)
-		return "", err
+	for _, alert := range msg.Alerts {
+		if alert.PanelURL != "" {
+			btns = append(btns, ActionCardButton{Title: "查看面板", ActionURL: alert.PanelURL})
+			break
+		}
+		if alert.DashboardURL != "" {
+			btns = append(btns, ActionCardButton{Title: "查看面板", ActionURL: alert.DashboardURL})
+			break
+		}
 	}
-	logger.Debug(string(sendDataBytes))
-
-	reqBody := bytes.NewBuffer(sendDataBytes)
-
-	// 创建 HTTP 请求
-	req, err := http.NewRequest("POST", dingdingUrl, reqBody)
-	if err != nil {
-		log.Fatalf("Failed to create request: %v", err)
-		return "", err
+	if silence := silenceURL(msg.ExternalURL, msg.Alerts); silence != "" {
+		btns = append(btns, ActionCardButton{Title: "静默报警", ActionURL: silence})
 	}
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
+	return btns
+}
 
-	// 发送 HTTP 请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		logger.Errorf("Failed to send request: %v", err)
-		return "", err
+// alertmanager 新建静默的页面地址，过滤条件为所有报警共同的标签，没有共同的标签时返回空
+func silenceURL(externalURL string, alerts []Alert) string {
+	if len(alerts) == 0 {
+		return ""
+	}
+	// grafana 的报警带有静默的地址，externalURL 不是 alertmanager 的地址，多条报警时不显示
+	if alerts[0].SilenceURL != "" {
+		if len(alerts) == 1 {
+			return alerts[0].SilenceURL
+		}
+		return ""
+	}
+	if externalURL == "" {
+		return ""
 	}
-	defer resp.Body.Close()
+	var matchers []string
+	for _, pair := range sortLabels(alerts[0].Labels) {
+		common := true
+		for _, alert := range alerts[1:] {
+			if value, ok := alert.Labels[pair.Name]; !ok || value != pair.Value {
+				common = false
+				break
+			}
+		}
+		if common {
+			matchers = append(matchers, fmt.Sprintf("%s=%q", pair.Name, pair.Value))
+		}
+	}
+	if len(matchers) == 0 {
+		return ""
+	}
+	filter := "{" + strings.Join(matchers, ",") + "}"
+	return strings.TrimRight(externalURL, "/") + "/#/silences/new?filter=" + url.QueryEscape(filter)
+}
 
-	// 获取发送钉钉消息后的相应
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logger.Errorf("Failed to read response body: %v", err)
-		return "", err
-	}
-	// 处理响应
-	if resp.StatusCode == http.StatusOK {
-		fmt.Println("Message sent successfully")
-		//fmt.Println(string(respBody))
-		logger.Debugf("发送钉钉相应 %s", string(respBody))
-		return string(respBody), nil
-	} else {
-		fmt.Printf("Failed to send message, status code: %d\n", resp.StatusCode)
-		//fmt.Println(string(respBody))
-		logger.Debugf("发送钉钉相应 %s", string(respBody))
-		return string(respBody), nil
+// FeedCard 的链接，每条合并的消息一个链接，标题为消息标题和报警名称，地址为报警的来源或 alertmanager 地址
+// 有消息找不到地址时返回 false
+func feedLinks(msg *Message) ([]FeedCardLink, bool) {
+	if len(msg.Items) == 0 {
+		return nil, false
 	}
+	links := make([]FeedCardLink, 0, len(msg.Items))
+	for _, item := range msg.Items {
+		link := FeedCardLink{Title: item.Title, MessageURL: item.ExternalURL}
+		if len(item.Alerts) > 0 {
+			alert := item.Alerts[0]
+			if name := alert.Labels["alertname"]; name != "" {
+				link.Title += " " + name
+			}
+			if len(item.Alerts) > 1 {
+				link.Title += fmt.Sprintf(" 等%d条报警", len(item.Alerts))
+			}
+			if alert.GeneratorURL != "" {
+				link.MessageURL = alert.GeneratorURL
+			}
+		}
+		if link.MessageURL == "" {
+			return nil, false
+		}
+		links = append(links, link)
+	}
+	return links, true
 }
 
-// 检查配置文件
-func checkConfig(cfg config.Config) (string, error) {
+// 拼接钉钉webhook地址，配置了secret时附带签名
+func (d *DingTalk) webhookURL() (string, error) {
 	var dingdingUrl string
 	timestamp := getTimestamp()
-	
-	baseURL, ok := cfg.App["webhook_url"].(string)
-	if !ok {
-		logger.Error("get webhook_url fail")
+
+	if d.receiver.WebhookURL == "" {
 		return "", errors.New("webhook_url must be provided")
 	}
-	
-	token, ok := cfg.App["token"].(string)
-	if !ok {
-		logger.Error("get token fail")
-		return "", errors.New("token must be provided")
-	}
-	
-	secret, ok := cfg.App["secret"].(string)
-	if ok {
-		sign := makeSign(fmt.Sprintf("%d", timestamp), secret)
-		dingdingUrl = baseURL + "access_token=" + token + "&timestamp=" + fmt.Sprintf("%d", timestamp) + "&sign=" + sign
+
+	if d.receiver.Secret != "" {
+		sign := makeSign(fmt.Sprintf("%d", timestamp), string(d.receiver.Secret))
+		dingdingUrl = d.receiver.WebhookURL + "access_token=" + string(d.receiver.Token) + "&timestamp=" + fmt.Sprintf("%d", timestamp) + "&sign=" + sign
 	} else {
-		dingdingUrl = baseURL + "access_token=" + token
+		dingdingUrl = d.receiver.WebhookURL + "access_token=" + string(d.receiver.Token)
 	}
-	
+
 	return dingdingUrl, nil
 }
 
@@ -148,3 +334,92 @@ func getTimestamp() int64 {
 	//fmt.Println(timestamp)
 	return timestamp
 }
+
+// 机器人配置了自定义关键词时，内容中没有关键词的消息会被钉钉拒绝（errcode 310000），在开头加上关键词
+func (d *DingTalk) withKeyword(content string) string {
+	keyword := d.receiver.Keyword
+	if keyword == "" || strings.Contains(content, keyword) {
+		return content
+	}
+	return keyword + " " + content
+}
+
+// 根据消息中的故障报警计算需要@的人，没有需要@的人时返回 nil
+// 依次合并固定配置的人、值班表当前的值班人员、报警标签或注解中的手机号和用户id、severity 对应的人和报警升级时@的人
+func (d *DingTalk) mentions(msg *Message) *DingTalkAt {
+	cfg := d.receiver.At
+	var firing []Alert
+	for _, alert := range msg.Alerts {
+		if alert.Status != "resolved" {
+			firing = append(firing, alert)
+		}
+	}
+	if len(firing) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	at := &DingTalkAt{IsAtAll: cfg.IsAtAll}
+	at.AtMobiles = appendUnique(at.AtMobiles, cfg.Mobiles...)
+	at.AtUserIds = appendUnique(at.AtUserIds, cfg.UserIDs...)
+	at.merge(d.schedules.mentions(cfg.Schedules, now))
+	for _, alert := range firing {
+		at.AtMobiles = appendUnique(at.AtMobiles, alertValues(alert, cfg.MobileLabels)...)
+		at.AtUserIds = appendUnique(at.AtUserIds, alertValues(alert, cfg.UserIDLabels)...)
+		if target, ok := cfg.Severity[alert.Labels["severity"]]; ok {
+			at.AtMobiles = appendUnique(at.AtMobiles, target.Mobiles...)
+			at.AtUserIds = appendUnique(at.AtUserIds, target.UserIDs...)
+			at.IsAtAll = at.IsAtAll || target.IsAtAll
+			at.merge(d.schedules.mentions(target.Schedules, now))
+		}
+	}
+	at.merge(msg.Mentions)
+	if len(at.AtMobiles) == 0 && len(at.AtUserIds) == 0 && !at.IsAtAll {
+		return nil
+	}
+	return at
+}
+
+// 合并另一组@的人
+func (at *DingTalkAt) merge(other *DingTalkAt) {
+	if other == nil {
+		return
+	}
+	at.AtMobiles = appendUnique(at.AtMobiles, other.AtMobiles...)
+	at.AtUserIds = appendUnique(at.AtUserIds, other.AtUserIds...)
+	at.IsAtAll = at.IsAtAll || other.IsAtAll
+}
+
+// 钉钉要求消息内容中包含 @手机号 或 @用户id 才会显示@，内容中没有时加在末尾
+func (d *DingTalk) withMentions(content string, at *DingTalkAt) string {
+	if at == nil {
+		return content
+	}
+	var missing []string
+	for _, id := range append(append([]string{}, at.AtMobiles...), at.AtUserIds...) {
+		if !strings.Contains(content, "@"+id) {
+			missing = append(missing, "@"+id)
+		}
+	}
+	if len(missing) == 0 {
+		return content
+	}
+	return content + "\n\n" + strings.Join(missing, " ")
+}
+
+// 从报警的标签或注解中读取值，多个值用逗号分隔，标签优先
+func alertValues(alert Alert, names []string) []string {
+	var values []string
+	for _, name := range names {
+		value, ok := alert.Labels[name]
+		if !ok {
+			value = alert.Annotations[name]
+		}
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
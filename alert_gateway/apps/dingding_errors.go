@@ -0,0 +1,55 @@
+package apps
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 钉钉接口返回的常见错误
+var (
+	// 130101 发送太快，超过了每分钟20条的限制
+	ErrDingTalkRateLimited = errors.New("dingding: send too fast, exceeded 20 messages per minute")
+	// 310000 消息不包含关键词、签名不匹配或者IP不在白名单中
+	ErrDingTalkSecurity = errors.New("dingding: keyword, signature or ip whitelist check failed")
+	// 300001 access_token 不存在或者无效
+	ErrDingTalkInvalidToken = errors.New("dingding: invalid access_token")
+	// 40035 缺少参数
+	ErrDingTalkMissingParam = errors.New("dingding: missing parameter")
+)
+
+// 钉钉错误码对应的错误
+var dingTalkErrors = map[int]error{
+	130101: ErrDingTalkRateLimited,
+	310000: ErrDingTalkSecurity,
+	300001: ErrDingTalkInvalidToken,
+	40035:  ErrDingTalkMissingParam,
+}
+
+// 钉钉接口的响应
+type DingTalkResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// 钉钉接口返回 errcode 不为 0 时的错误，已知的错误码可以用 errors.Is 判断，如 errors.Is(err, ErrDingTalkRateLimited)
+type DingTalkError struct {
+	Code int
+	Msg  string
+}
+
+func (e *DingTalkError) Error() string {
+	return fmt.Sprintf("dingding errcode %d: %s", e.Code, e.Msg)
+}
+
+func (e *DingTalkError) Unwrap() error {
+	return dingTalkErrors[e.Code]
+}
+
+// 关键词、签名、token 和参数错误重试也不会成功，不需要重试
+func (e *DingTalkError) Permanent() bool {
+	switch dingTalkErrors[e.Code] {
+	case ErrDingTalkSecurity, ErrDingTalkInvalidToken, ErrDingTalkMissingParam:
+		return true
+	}
+	return false
+}
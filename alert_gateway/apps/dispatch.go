@@ -0,0 +1,327 @@
+package apps
+
+import (
+	"alert_gateway/logger"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 一条待发送的消息，包含接收者和消息中的报警
+type batch struct {
+	receiver string
+	alerts   []Alert
+	// 报警在响应列表中的下标，用于把发送结果记录到对应的报警下
+	indexes []int
+	// 抖动通知为 flapping，抖动结束通知为 stable，普通消息为空
+	notice string
+}
+
+// 按路由树把报警分发给接收者并发送，返回每条报警的路由和发送结果，以及发送失败并且没有放入重试队列的消息数量
+// [app] 中 group_by = "payload" 时每个接收者只发送一条包含本次所有报警的消息，否则每条报警发送一条消息
+func (app *App) dispatch(alertData AlertData) ([]map[string]interface{}, int) {
+	// 处理过程中配置可能被重新加载，整个请求使用同一份配置
+	state := app.current()
+	grouped := state.groupBy() == "payload"
+	// 先记录本次请求中的源报警，同一个请求中的目标报警也会被抑制
+	if state.inhibitor != nil {
+		for _, alert := range alertData.Alerts {
+			state.inhibitor.Observe(alert)
+		}
+	}
+	// 记录状态变化，刚开始抖动的报警发送一条抖动通知
+	notices := make([]bool, len(alertData.Alerts))
+	if state.flapping != nil {
+		for i, alert := range alertData.Alerts {
+			notices[i] = state.flapping.Observe(alert, alertData.ExternalURL)
+		}
+	}
+
+	responses := make([]map[string]interface{}, 0, len(alertData.Alerts))
+	var batches []*batch
+	receiverBatches := make(map[string]*batch)
+	for i, alert := range alertData.Alerts {
+		if reason := app.suppressed(state, alert, notices[i]); reason != "" {
+			logger.Infof("alert %s %s suppressed: %s", alert.Labels["alertname"], alertFingerprint(alert), reason)
+			alertsSuppressed.WithLabelValues(suppressedReason(reason)).Inc()
+			responses = append(responses, map[string]interface{}{
+				"alert":      alert.Labels["instance"],
+				"receivers":  []string{},
+				"deliveries": []map[string]interface{}{},
+				"suppressed": reason,
+			})
+			continue
+		}
+
+		receivers := state.route.Receivers(alert.Labels)
+		logger.Debugf("alert %s routed to %v", alert.Labels["alertname"], receivers)
+
+		responses = append(responses, map[string]interface{}{
+			"alert":      alert.Labels["instance"],
+			"receivers":  receivers,
+			"deliveries": []map[string]interface{}{},
+		})
+
+		notice := ""
+		if notices[i] {
+			notice = "flapping"
+		}
+		for _, name := range receivers {
+			if !grouped {
+				batches = append(batches, &batch{receiver: name, alerts: []Alert{alert}, indexes: []int{i}, notice: notice})
+				continue
+			}
+			// 抖动通知和普通报警分开发送
+			key := name + "\x00" + notice
+			b, ok := receiverBatches[key]
+			if !ok {
+				b = &batch{receiver: name, notice: notice}
+				receiverBatches[key] = b
+				batches = append(batches, b)
+			}
+			b.alerts = append(b.alerts, alert)
+			b.indexes = append(b.indexes, i)
+		}
+	}
+
+	failed := 0
+	alertFailed := make([]bool, len(alertData.Alerts))
+	for _, b := range batches {
+		delivery, ok := app.deliver(state, alertData, b, grouped)
+		if !ok {
+			failed++
+		}
+		for _, i := range b.indexes {
+			responses[i]["deliveries"] = append(responses[i]["deliveries"].([]map[string]interface{}), delivery)
+			if !ok {
+				alertFailed[i] = true
+			}
+		}
+	}
+
+	for i, alert := range alertData.Alerts {
+		suppressed, _ := responses[i]["suppressed"].(string)
+		if suppressed == "" && alertFailed[i] && !notices[i] {
+			state.release(alert)
+		}
+		app.trackEscalation(state, alertData, alert, suppressed)
+	}
+	app.recordHistory(alertData.Alerts, responses)
+	return responses, failed
+}
+
+// 判断报警是否需要抑制，返回抑制的原因，不需要抑制时返回空字符串
+// notice 为 true 时报警需要发送抖动通知或抖动结束通知，不检查抖动和去重
+// 需要发送的报警会记录到去重中，发送失败时调用 release 删除
+func (app *App) suppressed(state *appState, alert Alert, notice bool) string {
+	if id := app.silences.Mutes(alert.Labels); id != "" {
+		return "silenced by " + id
+	}
+	if state.inhibitor != nil {
+		if source := state.inhibitor.Inhibits(alert); source != "" {
+			return "inhibited by " + source
+		}
+	}
+	if notice {
+		return ""
+	}
+	if state.flapping != nil && state.flapping.Flapping(alert) {
+		return "flapping"
+	}
+	if state.dedup != nil && !state.dedup.Reserve(alert) {
+		return "duplicate"
+	}
+	return ""
+}
+
+// 抑制原因的类别，用作监控指标的标签，避免静默 id 和源报警的指纹导致标签值过多
+func suppressedReason(reason string) string {
+	category, _, _ := strings.Cut(reason, " ")
+	return category
+}
+
+// 报警发送失败时删除去重记录，alertmanager 重新推送时不会被当作重复的报警
+func (s *appState) release(alert Alert) {
+	if s.dedup != nil {
+		s.dedup.Release(alert)
+	}
+}
+
+// 读取 [app] 中配置的分组方式
+func (s *appState) groupBy() string {
+	if s.config.App.GroupBy == "" {
+		return "alert"
+	}
+	return s.config.App.GroupBy
+}
+
+// 渲染并发送一条消息，返回发送结果，发送失败并且没有放入重试队列时 ok 为 false，放入死信也算失败
+func (app *App) deliver(state *appState, alertData AlertData, b *batch, grouped bool) (delivery map[string]interface{}, ok bool) {
+	notifier := state.notifiers[b.receiver]
+	delivery = map[string]interface{}{
+		"receiver": b.receiver,
+		"respMsg":  "",
+		"errcode":  0,
+		"error":    nil,
+		"queued":   false,
+		"dead":     false,
+		"held":     false,
+	}
+
+	msg, err := state.newMessage(alertData, b.alerts, notifier, grouped)
+	if err != nil {
+		logger.Errorf("Failed to render message to %s: %v", b.receiver, err)
+		delivery["error"] = errorString(err)
+		recordDelivery(b.receiver, "failed")
+		return delivery, false
+	}
+	if b.notice != "" && state.flapping != nil {
+		msg.Content = state.flapping.header(msg.Type, b.notice == "stable") + msg.Content
+	}
+	var result *Result
+	if app.draining.Load() && app.queue != nil {
+		// 退出超时后不再发送，直接放入重试队列
+		err = errShuttingDown
+	} else {
+		result, err = notifier.Send(msg)
+	}
+	if result != nil {
+		delivery["respMsg"] = result.RespMsg
+		delivery["errcode"] = result.ErrCode
+		delivery["held"] = result.Held
+		if err == nil {
+			err = checkResult(result)
+		}
+	}
+	if err == nil {
+		logger.Debugf("Response message from %s: %s", b.receiver, delivery["respMsg"])
+		if result != nil && result.Held {
+			recordDelivery(b.receiver, "held")
+		} else {
+			recordDelivery(b.receiver, "success")
+		}
+		return delivery, true
+	}
+
+	logger.Errorf("Failed to send message to %s: %v", b.receiver, err)
+	delivery["error"] = errorString(err)
+	// 发送失败的消息放入重试队列，重试也不会成功的错误会直接放入死信
+	if app.queue == nil {
+		recordDelivery(b.receiver, "failed")
+		return delivery, false
+	}
+	if _, qerr := app.queue.Enqueue(b.receiver, msg, err); qerr != nil {
+		logger.Errorf("Failed to queue message to %s: %v", b.receiver, qerr)
+		recordDelivery(b.receiver, "failed")
+		return delivery, false
+	}
+	// 重试也不会成功的消息已经保存在死信中，没有送达，仍然算作发送失败
+	if isPermanent(err) {
+		recordDelivery(b.receiver, "failed")
+		delivery["dead"] = true
+		return delivery, false
+	}
+	recordDelivery(b.receiver, "queued")
+	delivery["queued"] = true
+	return delivery, true
+}
+
+// 汇总消息发送失败时放入重试队列
+func (app *App) queueFailed(receiver string, msg *Message, err error) {
+	if app.queue == nil {
+		return
+	}
+	if _, qerr := app.queue.Enqueue(receiver, msg, err); qerr != nil {
+		logger.Errorf("Failed to queue message to %s: %v", receiver, qerr)
+	}
+}
+
+// 重新发送重试队列中的消息
+func (app *App) sendEntry(entry *QueueEntry) error {
+	notifier, ok := app.current().notifiers[entry.Receiver]
+	if !ok {
+		return fmt.Errorf("receiver %s not found", entry.Receiver)
+	}
+	result, err := notifier.Send(entry.Message)
+	if err == nil {
+		err = checkResult(result)
+	}
+	delivery := DeliveryRecord{Receiver: entry.Receiver, Result: "success", At: time.Now()}
+	switch {
+	case err != nil:
+		delivery.Result = "failed"
+		delivery.Detail = err.Error()
+	case result.Held:
+		delivery.Result = "held"
+	}
+	recordDelivery(entry.Receiver, delivery.Result)
+	if app.history != nil {
+		if herr := app.history.AddDelivery(entry.Message.Alerts, delivery); herr != nil {
+			logger.Errorf("Failed to record alert history: %v", herr)
+		}
+	}
+	return err
+}
+
+// 使用接收者的模板渲染一条消息
+// 分组消息中故障报警排在恢复报警前面，内容超过接收者的消息长度限制时从后往前去掉报警，并在模板中通过 .Truncated 显示省略的数量
+func (s *appState) newMessage(alertData AlertData, alerts []Alert, notifier Notifier, grouped bool) (*Message, error) {
+	alerts = append([]Alert(nil), alerts...)
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].Status != "resolved" && alerts[j].Status == "resolved"
+	})
+
+	status := "resolved"
+	title := "恢复"
+	if len(alerts) > 0 && alerts[0].Status != "resolved" {
+		status = "firing"
+		title = "故障"
+	}
+
+	name := notifier.Template()
+	if name == "" {
+		name = s.templates.defaultName(notifier.MessageType(), grouped)
+	}
+
+	data := &TemplateData{AlertData: alertData, Title: title}
+	data.Status = status
+	data.Alerts = alerts
+	if grouped {
+		// alertmanager 配置了 max_alerts 时被截掉的报警数量也算在省略的数量里
+		data.Truncated = alertData.TruncatedAlerts
+	}
+	content, err := s.templates.Render(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize := notifier.MaxMessageSize()
+	for grouped && maxSize > 0 && len(content) > maxSize && len(data.Alerts) > 1 {
+		// 按超出的比例估算需要保留的报警数量，至少去掉一条
+		keep := len(data.Alerts) * maxSize / len(content)
+		if keep >= len(data.Alerts) {
+			keep = len(data.Alerts) - 1
+		}
+		if keep < 1 {
+			keep = 1
+		}
+		data.Alerts = alerts[:keep]
+		data.Truncated = alertData.TruncatedAlerts + len(alerts) - keep
+		if content, err = s.templates.Render(name, data); err != nil {
+			return nil, err
+		}
+	}
+	if maxSize > 0 && len(content) > maxSize {
+		logger.Errorf("message to %s is %d bytes, exceeding the limit of %d bytes", notifier.Name(), len(content), maxSize)
+	}
+
+	return &Message{
+		Title:       title,
+		Content:     content,
+		Type:        notifier.MessageType(),
+		Status:      status,
+		Alerts:      alerts,
+		ExternalURL: alertData.ExternalURL,
+	}, nil
+}
@@ -0,0 +1,405 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var escalationBucket = []byte("escalations")
+
+// 已经执行完所有步骤或已经确认的升级，超过这个时间没有再收到报警时删除，避免恢复通知丢失后一直保留
+const escalationRetention = 7 * 24 * time.Hour
+
+// 一条故障报警的升级进度
+type EscalationState struct {
+	Fingerprint string `json:"fingerprint"`
+	Severity    string `json:"severity"`
+	Alert       Alert  `json:"alert"`
+	ExternalURL string `json:"externalURL"`
+	// 第一次和最后一次收到故障报警的时间，升级步骤的 after 从 StartedAt 开始计算
+	StartedAt time.Time `json:"startedAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// 下一步的序号和执行时间，所有步骤执行完后 NextAt 为零值
+	Step   int       `json:"step"`
+	NextAt time.Time `json:"nextAt"`
+	// 确认的人和时间，确认后不再升级
+	AckedBy string    `json:"ackedBy,omitempty"`
+	AckedAt time.Time `json:"ackedAt"`
+}
+
+// 是否还有需要执行的步骤
+func (s *EscalationState) pending() bool {
+	return s.AckedBy == "" && !s.NextAt.IsZero()
+}
+
+// 报警升级进度，保存在 bbolt 文件中，进程重启后继续升级
+type Escalations struct {
+	db *bolt.DB
+}
+
+// 打开升级进度文件
+func OpenEscalations(path string) (*Escalations, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open escalations %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(escalationBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init escalations %s: %w", path, err)
+	}
+	return &Escalations{db: db}, nil
+}
+
+func (e *Escalations) Close() error {
+	return e.db.Close()
+}
+
+// 收到故障报警时开始升级，已经在升级的报警只更新报警内容
+func (e *Escalations) Start(alert Alert, externalURL string, policy *config.EscalationPolicy) error {
+	now := time.Now()
+	fingerprint := alertFingerprint(alert)
+	return e.db.Update(func(tx *bolt.Tx) error {
+		state, err := getEscalation(tx, fingerprint)
+		if err != nil {
+			return err
+		}
+		if state == nil {
+			state = &EscalationState{
+				Fingerprint: fingerprint,
+				Severity:    policy.Severity,
+				StartedAt:   now,
+				NextAt:      now.Add(policy.Steps[0].After),
+			}
+		}
+		state.Alert = alert
+		state.ExternalURL = externalURL
+		state.LastSeen = now
+		return putEscalation(tx, state)
+	})
+}
+
+// 报警恢复时停止升级
+func (e *Escalations) Resolve(alert Alert) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(escalationBucket).Delete([]byte(alertFingerprint(alert)))
+	})
+}
+
+// 确认报警，停止升级
+func (e *Escalations) Ack(fingerprint, by string) (*EscalationState, error) {
+	var state *EscalationState
+	err := e.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if state, err = getEscalation(tx, fingerprint); err != nil {
+			return err
+		}
+		if state == nil {
+			return fmt.Errorf("escalation %s not found", fingerprint)
+		}
+		if state.AckedBy != "" {
+			return fmt.Errorf("escalation %s already acknowledged by %s", fingerprint, state.AckedBy)
+		}
+		state.AckedBy = by
+		state.AckedAt = time.Now()
+		return putEscalation(tx, state)
+	})
+	return state, err
+}
+
+// 列出所有的升级进度
+func (e *Escalations) List() ([]*EscalationState, error) {
+	states := []*EscalationState{}
+	err := e.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(escalationBucket).ForEach(func(k, v []byte) error {
+			var state EscalationState
+			if err := json.Unmarshal(v, &state); err != nil {
+				return err
+			}
+			states = append(states, &state)
+			return nil
+		})
+	})
+	return states, err
+}
+
+// 下一步到期的升级，同时删除已经结束并且很久没有收到报警的升级
+func (e *Escalations) due(now time.Time) ([]*EscalationState, error) {
+	var due []*EscalationState
+	var stale []string
+	err := e.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(escalationBucket).ForEach(func(k, v []byte) error {
+			var state EscalationState
+			if err := json.Unmarshal(v, &state); err != nil {
+				logger.Errorf("Failed to decode escalation %s: %v", k, err)
+				return nil
+			}
+			switch {
+			case state.pending() && !state.NextAt.After(now):
+				due = append(due, &state)
+			case !state.pending() && now.Sub(state.LastSeen) > escalationRetention:
+				stale = append(stale, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil || len(stale) == 0 {
+		return due, err
+	}
+	err = e.db.Update(func(tx *bolt.Tx) error {
+		for _, k := range stale {
+			if err := tx.Bucket(escalationBucket).Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return due, err
+}
+
+// 执行完一步后更新进度，升级在执行期间被确认、恢复或已经被更新时不修改
+func (e *Escalations) advance(state *EscalationState, next time.Time) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		current, err := getEscalation(tx, state.Fingerprint)
+		if err != nil || current == nil || current.AckedBy != "" || current.Step != state.Step {
+			return err
+		}
+		current.Step++
+		current.NextAt = next
+		return putEscalation(tx, current)
+	})
+}
+
+// 删除升级，升级策略被删除时使用
+func (e *Escalations) remove(fingerprint string) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(escalationBucket).Delete([]byte(fingerprint))
+	})
+}
+
+func getEscalation(tx *bolt.Tx, fingerprint string) (*EscalationState, error) {
+	v := tx.Bucket(escalationBucket).Get([]byte(fingerprint))
+	if v == nil {
+		return nil, nil
+	}
+	var state EscalationState
+	if err := json.Unmarshal(v, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func putEscalation(tx *bolt.Tx, state *EscalationState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(escalationBucket).Put([]byte(state.Fingerprint), data)
+}
+
+// 升级进度文件的路径，默认为 escalations.db，没有配置升级策略时返回空字符串
+func escalationsPath(cfg *config.Config) string {
+	if len(cfg.Escalation.Policies) == 0 {
+		return ""
+	}
+	if cfg.Escalation.Path == "" {
+		return "escalations.db"
+	}
+	return cfg.Escalation.Path
+}
+
+// 报警的 severity 对应的升级策略，没有时返回 nil
+func (s *appState) escalationPolicy(severity string) *config.EscalationPolicy {
+	for i := range s.config.Escalation.Policies {
+		if policy := &s.config.Escalation.Policies[i]; policy.Severity == severity {
+			return policy
+		}
+	}
+	return nil
+}
+
+// 根据报警的处理结果开始或停止升级
+// 恢复的报警停止升级，发送或去重的故障报警开始升级，被静默或抑制的故障报警不升级
+func (app *App) trackEscalation(state *appState, alertData AlertData, alert Alert, suppressed string) {
+	if app.escalations == nil {
+		return
+	}
+	var err error
+	if alert.Status == "resolved" {
+		err = app.escalations.Resolve(alert)
+	} else if policy := state.escalationPolicy(alert.Labels["severity"]); policy != nil && (suppressed == "" || suppressed == "duplicate") {
+		err = app.escalations.Start(alert, alertData.ExternalURL, policy)
+	}
+	if err != nil {
+		logger.Errorf("Failed to update escalation of %s: %v", alertFingerprint(alert), err)
+	}
+}
+
+// 每秒检查一次到期的升级，直到 stop 被关闭
+func (app *App) runEscalations(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			app.escalateDue()
+		}
+	}
+}
+
+// 执行到期的升级步骤，报警在升级时被静默或抑制的跳过这一步
+func (app *App) escalateDue() {
+	now := time.Now()
+	due, err := app.escalations.due(now)
+	if err != nil {
+		logger.Errorf("Failed to read escalations: %v", err)
+		return
+	}
+	state := app.current()
+	for _, escalation := range due {
+		policy := state.escalationPolicy(escalation.Severity)
+		if policy == nil || escalation.Step >= len(policy.Steps) {
+			// 重新加载配置后升级策略被删除或步骤减少
+			if err := app.escalations.remove(escalation.Fingerprint); err != nil {
+				logger.Errorf("Failed to remove escalation %s: %v", escalation.Fingerprint, err)
+			}
+			continue
+		}
+
+		alert := escalation.Alert
+		if id := app.silences.Mutes(alert.Labels); id != "" {
+			logger.Infof("escalation step %d of %s skipped: silenced by %s", escalation.Step+1, escalation.Fingerprint, id)
+		} else if state.inhibitor != nil && state.inhibitor.Inhibits(alert) != "" {
+			logger.Infof("escalation step %d of %s skipped: inhibited", escalation.Step+1, escalation.Fingerprint)
+		} else {
+			app.escalate(state, escalation, policy.Steps[escalation.Step])
+		}
+
+		var next time.Time
+		if escalation.Step+1 < len(policy.Steps) {
+			next = escalation.StartedAt.Add(policy.Steps[escalation.Step+1].After)
+		}
+		if err := app.escalations.advance(escalation, next); err != nil {
+			logger.Errorf("Failed to update escalation %s: %v", escalation.Fingerprint, err)
+		}
+	}
+}
+
+// 发送一步升级消息，消息开头说明升级的级别和确认的方法，发送失败时放入重试队列
+func (app *App) escalate(state *appState, escalation *EscalationState, step config.EscalationStep) {
+	notifier, ok := state.notifiers[step.Receiver]
+	if !ok {
+		logger.Errorf("escalation %s: receiver %s not found", escalation.Fingerprint, step.Receiver)
+		return
+	}
+	alertData := AlertData{
+		Status:       "firing",
+		Alerts:       []Alert{escalation.Alert},
+		CommonLabels: escalation.Alert.Labels,
+		ExternalURL:  escalation.ExternalURL,
+	}
+	msg, err := state.newMessage(alertData, alertData.Alerts, notifier, false)
+	if err != nil {
+		logger.Errorf("Failed to render escalation %s to %s: %v", escalation.Fingerprint, step.Receiver, err)
+		return
+	}
+	msg.Content = escalationHeader(msg.Type, escalation) + msg.Content
+	if step.Configured() {
+		msg.Mentions = &DingTalkAt{IsAtAll: step.IsAtAll}
+		msg.Mentions.merge(&DingTalkAt{AtMobiles: step.Mobiles, AtUserIds: step.UserIDs})
+		msg.Mentions.merge(state.schedules.mentions(step.Schedules, time.Now()))
+	}
+
+	logger.Infof("escalating %s %s to %s (step %d)", escalation.Alert.Labels["alertname"], escalation.Fingerprint, step.Receiver, escalation.Step+1)
+	result, err := notifier.Send(msg)
+	if err == nil {
+		err = checkResult(result)
+	}
+	delivery := DeliveryRecord{Receiver: step.Receiver, Result: "success", Detail: fmt.Sprintf("escalation step %d", escalation.Step+1), At: time.Now()}
+	switch {
+	case err != nil && app.queue != nil && !isPermanent(err):
+		delivery.Result = "queued"
+	case err != nil:
+		delivery.Result = "failed"
+	case result.Held:
+		delivery.Result = "held"
+	}
+	recordDelivery(step.Receiver, delivery.Result)
+	if err != nil {
+		logger.Errorf("Failed to send escalation %s to %s: %v", escalation.Fingerprint, step.Receiver, err)
+		delivery.Detail += ": " + err.Error()
+		app.queueFailed(step.Receiver, msg, err)
+	}
+	if app.history != nil {
+		if herr := app.history.AddDelivery(alertData.Alerts, delivery); herr != nil {
+			logger.Errorf("Failed to record alert history: %v", herr)
+		}
+	}
+}
+
+// 升级消息的开头
+func escalationHeader(messageType string, escalation *EscalationState) string {
+	elapsed := time.Since(escalation.StartedAt).Round(time.Second)
+	ack := fmt.Sprintf("POST /api/escalations/%s/ack", escalation.Fingerprint)
+	if messageType == "text" {
+		return fmt.Sprintf("【报警升级 第%d级】故障已持续 %s 未确认，确认后停止升级：%s\n\n", escalation.Step+1, elapsed, ack)
+	}
+	return fmt.Sprintf("### 报警升级 第%d级\n\n故障已持续 %s 未确认，确认后停止升级：`%s`\n\n", escalation.Step+1, elapsed, ack)
+}
+
+// 查看升级进度，GET /api/escalations
+func (app *App) handleEscalations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	states, err := app.escalations.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, states)
+}
+
+// 确认报警，停止升级，POST /api/escalations/<fingerprint>/ack，请求体为 {"ackedBy": "zhangsan"}
+func (app *App) handleEscalation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fingerprint, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/escalations/"), "/ack")
+	if !ok || fingerprint == "" {
+		http.NotFound(w, r)
+		return
+	}
+	var body struct {
+		AckedBy string `json:"ackedBy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("解析json数据失败: %v", err), http.StatusBadRequest)
+		return
+	}
+	if body.AckedBy == "" {
+		http.Error(w, "ackedBy must be provided", http.StatusBadRequest)
+		return
+	}
+	state, err := app.escalations.Ack(fingerprint, body.AckedBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.Infof("escalation %s acknowledged by %s", fingerprint, body.AckedBy)
+	writeJSON(w, http.StatusOK, state)
+}
@@ -0,0 +1,132 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// 飞书文本消息结构
+type FeishuTextMessage struct {
+	Timestamp string        `json:"timestamp,omitempty"`
+	Sign      string        `json:"sign,omitempty"`
+	MsgType   string        `json:"msg_type"`
+	Content   FeishuContent `json:"content"`
+}
+
+// 飞书文本内容结构
+type FeishuContent struct {
+	Text string `json:"text"`
+}
+
+// 飞书卡片消息结构，用于发送markdown内容
+type FeishuCardMessage struct {
+	Timestamp string     `json:"timestamp,omitempty"`
+	Sign      string     `json:"sign,omitempty"`
+	MsgType   string     `json:"msg_type"`
+	Card      FeishuCard `json:"card"`
+}
+
+// 飞书卡片结构
+type FeishuCard struct {
+	Header   FeishuCardHeader    `json:"header"`
+	Elements []FeishuCardElement `json:"elements"`
+}
+
+// 飞书卡片标题
+type FeishuCardHeader struct {
+	Title    FeishuCardText `json:"title"`
+	Template string         `json:"template"`
+}
+
+// 飞书卡片文本
+type FeishuCardText struct {
+	Tag     string `json:"tag"`
+	Content string `json:"content"`
+}
+
+// 飞书卡片元素
+type FeishuCardElement struct {
+	Tag     string `json:"tag"`
+	Content string `json:"content"`
+}
+
+// 飞书/Lark自定义机器人通知后端
+type Feishu struct {
+	receiverBase
+}
+
+func newFeishu(receiver config.Receiver) (*Feishu, error) {
+	if receiver.WebhookURL == "" {
+		if receiver.Type == "lark" {
+			receiver.WebhookURL = "https://open.larksuite.com/open-apis/bot/v2/hook/"
+		} else {
+			receiver.WebhookURL = "https://open.feishu.cn/open-apis/bot/v2/hook/"
+		}
+	}
+	if receiver.Token == "" {
+		return nil, fmt.Errorf("receiver %s: token must be provided", receiver.Name)
+	}
+	return &Feishu{receiverBase{receiver: receiver}}, nil
+}
+
+func (f *Feishu) Send(msg *Message) (*Result, error) {
+	var timestamp, sign string
+	if f.receiver.Secret != "" {
+		timestamp = fmt.Sprintf("%d", time.Now().Unix())
+		sign = makeFeishuSign(timestamp, string(f.receiver.Secret))
+	}
+
+	var sendData any
+	if msg.Type == "text" {
+		sendData = FeishuTextMessage{
+			Timestamp: timestamp,
+			Sign:      sign,
+			MsgType:   "text",
+			Content:   FeishuContent{Text: msg.Content},
+		}
+	} else {
+		template := "red"
+		if msg.Status == "resolved" {
+			template = "green"
+		}
+		sendData = FeishuCardMessage{
+			Timestamp: timestamp,
+			Sign:      sign,
+			MsgType:   "interactive",
+			Card: FeishuCard{
+				Header: FeishuCardHeader{
+					Title:    FeishuCardText{Tag: "plain_text", Content: msg.Title},
+					Template: template,
+				},
+				Elements: []FeishuCardElement{{Tag: "markdown", Content: msg.Content}},
+			},
+		}
+	}
+
+	sendDataBytes, err := json.Marshal(sendData)
+	if err != nil {
+		logger.Errorf("Failed to marshal JSON: %v", err)
+		return nil, err
+	}
+	logger.Debug(string(sendDataBytes))
+
+	statusCode, respBody, err := postJSON(f.receiver.WebhookURL+string(f.receiver.Token), sendDataBytes, nil)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("发送飞书响应 %s", respBody)
+	return &Result{Receiver: f.Name(), StatusCode: statusCode, RespMsg: respBody}, nil
+}
+
+// 飞书签名：以 timestamp + "\n" + secret 作为密钥，对空字符串计算 HMAC-SHA256 后 Base64 编码
+func makeFeishuSign(timestamp, secret string) string {
+	stringToSign := timestamp + "\n" + secret
+	h := hmac.New(sha256.New, []byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
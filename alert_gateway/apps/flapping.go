@@ -0,0 +1,202 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 一个报警的状态变化记录
+type flapEntry struct {
+	status     string
+	changes    []time.Time // window 内每次状态变化的时间
+	lastChange time.Time
+	flapping   bool
+	// 最后收到的报警，抖动结束时发送报警的最终状态
+	alert       Alert
+	externalURL string
+}
+
+// 按指纹检测抖动的报警
+// 阈值附近的指标会让 alertmanager 交替发送故障和恢复通知，window 内状态变化达到 threshold 次时认为报警在抖动，
+// 抖动开始时发送一条抖动通知，之后不再发送，状态在 stable 时间内没有变化后发送一次最终状态并恢复正常通知
+type FlapDetector struct {
+	mu        sync.Mutex
+	window    time.Duration
+	threshold int
+	stable    time.Duration
+	entries   map[string]*flapEntry
+}
+
+func NewFlapDetector(cfg config.Flapping) *FlapDetector {
+	f := &FlapDetector{entries: make(map[string]*flapEntry)}
+	f.SetConfig(cfg)
+	return f
+}
+
+// 修改检测的参数，已经在抖动的报警按新的 stable 判断是否结束
+func (f *FlapDetector) SetConfig(cfg config.Flapping) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.window = cfg.Window
+	f.threshold = cfg.Threshold
+	f.stable = cfg.Stable
+	if f.stable <= 0 {
+		f.stable = cfg.Window
+	}
+}
+
+// 记录收到的报警，报警从这一次开始抖动时返回 true
+func (f *FlapDetector) Observe(alert Alert, externalURL string) bool {
+	fingerprint := alertFingerprint(alert)
+	status := "firing"
+	if alert.Status == "resolved" {
+		status = "resolved"
+	}
+	now := time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.entries[fingerprint]
+	if !ok {
+		entry = &flapEntry{status: status, lastChange: now}
+		f.entries[fingerprint] = entry
+	}
+	entry.alert = alert
+	entry.externalURL = externalURL
+	if entry.status == status {
+		return false
+	}
+
+	entry.status = status
+	entry.lastChange = now
+	entry.changes = append(entry.changes, now)
+	// 去掉窗口之前的状态变化
+	i := 0
+	for i < len(entry.changes) && now.Sub(entry.changes[i]) > f.window {
+		i++
+	}
+	entry.changes = entry.changes[i:]
+	if !entry.flapping && len(entry.changes) >= f.threshold {
+		entry.flapping = true
+		logger.Infof("alert %s %s is flapping, %d state changes within %s", alert.Labels["alertname"], fingerprint, len(entry.changes), f.window)
+		return true
+	}
+	return false
+}
+
+// 报警是否正在抖动
+func (f *FlapDetector) Flapping(alert Alert) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.entries[alertFingerprint(alert)]
+	return ok && entry.flapping
+}
+
+// 正在抖动的报警数量
+func (f *FlapDetector) Count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, entry := range f.entries {
+		if entry.flapping {
+			count++
+		}
+	}
+	return count
+}
+
+// 返回 stable 时间内状态没有变化、抖动已经结束的报警，并删除很久没有变化的记录
+func (f *FlapDetector) stabilized(now time.Time) []flapEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var stabilized []flapEntry
+	for fingerprint, entry := range f.entries {
+		idle := now.Sub(entry.lastChange)
+		switch {
+		case entry.flapping && idle >= f.stable:
+			entry.flapping = false
+			entry.changes = nil
+			stabilized = append(stabilized, *entry)
+		case !entry.flapping && idle > f.window:
+			delete(f.entries, fingerprint)
+		}
+	}
+	return stabilized
+}
+
+// 抖动通知和抖动结束通知的开头，stable 为 true 时为抖动结束通知
+func (f *FlapDetector) header(messageType string, stable bool) string {
+	f.mu.Lock()
+	window, threshold, stableFor := f.window, f.threshold, f.stable
+	f.mu.Unlock()
+	title := "报警抖动"
+	text := fmt.Sprintf("%s 内状态变化 %d 次，状态 %s 没有变化之前不再通知", window, threshold, stableFor)
+	if stable {
+		title = "报警已稳定"
+		text = fmt.Sprintf("状态已经 %s 没有变化，恢复正常通知，当前状态如下", stableFor)
+	}
+	if messageType == "text" {
+		return fmt.Sprintf("【%s】%s\n\n", title, text)
+	}
+	return fmt.Sprintf("### %s\n\n%s\n\n", title, text)
+}
+
+// 每秒检查一次抖动是否结束，直到 stop 被关闭
+func (app *App) runFlapDetector(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			app.notifyStabilized()
+		}
+	}
+}
+
+// 抖动结束后按路由发送一次报警的当前状态，抖动期间的恢复通知被抑制，不发送的话报警会一直显示为故障
+func (app *App) notifyStabilized() {
+	state := app.current()
+	if state.flapping == nil {
+		return
+	}
+	for _, entry := range state.flapping.stabilized(time.Now()) {
+		alert := entry.alert
+		fingerprint := alertFingerprint(alert)
+		logger.Infof("alert %s %s stopped flapping, status %s", alert.Labels["alertname"], fingerprint, alert.Status)
+		if reason := app.suppressed(state, alert, true); reason != "" {
+			logger.Infof("alert %s %s suppressed: %s", alert.Labels["alertname"], fingerprint, reason)
+			continue
+		}
+
+		alertData := AlertData{
+			Status:       alert.Status,
+			Alerts:       []Alert{alert},
+			CommonLabels: alert.Labels,
+			ExternalURL:  entry.externalURL,
+		}
+		ok := true
+		for _, name := range state.route.Receivers(alert.Labels) {
+			delivery, delivered := app.deliver(state, alertData, &batch{receiver: name, alerts: alertData.Alerts, notice: "stable"}, false)
+			ok = ok && delivered
+			if app.history != nil {
+				record := deliveryRecordOf(delivery, time.Now())
+				if record.Detail == "" {
+					record.Detail = "flapping stopped"
+				}
+				if err := app.history.AddDelivery(alertData.Alerts, record); err != nil {
+					logger.Errorf("Failed to record alert history: %v", err)
+				}
+			}
+		}
+		// 最终状态发送成功后开始新的去重窗口，alertmanager 重复推送的同一个状态不再发送
+		if ok && state.dedup != nil {
+			state.dedup.Reserve(alert)
+		}
+		app.trackEscalation(state, alertData, alert, "")
+	}
+}
@@ -0,0 +1,138 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// 就绪检查中连接 webhook 主机的超时时间
+const dialTimeout = 3 * time.Second
+
+// 存活检查，进程能处理请求就返回 200，GET /healthz
+func (app *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// 就绪检查，GET /readyz
+// 检查配置是否有效、日志文件是否可写，[app] 中 readiness_check_webhooks = true 时还检查每个 webhook 主机能否解析和连接
+// 退出过程中或有检查失败时返回 503，负载均衡据此摘掉流量
+func (app *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	checks := map[string]string{}
+	ready := app.ready.Load()
+	if !ready {
+		checks["server"] = "shutting down"
+	}
+	for name, err := range app.readinessChecks() {
+		if err != nil {
+			checks[name] = err.Error()
+			ready = false
+		} else {
+			checks[name] = "ok"
+		}
+	}
+
+	status := http.StatusOK
+	response := map[string]interface{}{"status": "ready", "checks": checks}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		response["status"] = "not ready"
+	}
+	writeJSON(w, status, response)
+}
+
+// 执行所有就绪检查，返回检查名称和错误
+func (app *App) readinessChecks() map[string]error {
+	state := app.current()
+	results := map[string]error{
+		"config": state.checkConfig(),
+		"log":    checkLogWritable(state.config.Log),
+	}
+	if !state.config.App.ReadinessCheckWebhooks {
+		return results
+	}
+
+	// 多个接收者可能使用同一个主机，每个主机只检查一次
+	hosts := map[string][]string{}
+	for name, notifier := range state.notifiers {
+		host, err := notifierHost(notifier)
+		if err != nil {
+			results["webhook "+name] = err
+			continue
+		}
+		hosts[host] = append(hosts[host], name)
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for host, names := range hosts {
+		wg.Add(1)
+		go func(host string, names []string) {
+			defer wg.Done()
+			err := dialHost(host)
+			mu.Lock()
+			defer mu.Unlock()
+			for _, name := range names {
+				results["webhook "+name] = err
+			}
+		}(host, names)
+	}
+	wg.Wait()
+	return results
+}
+
+// 检查配置是否已加载并且有效
+func (s *appState) checkConfig() error {
+	if s == nil || s.config == nil || s.route == nil || s.templates == nil {
+		return fmt.Errorf("config not loaded")
+	}
+	if len(s.notifiers) == 0 {
+		return fmt.Errorf("no receivers configured")
+	}
+	return s.route.validate(s.notifiers)
+}
+
+// 日志类型为 file 或 all 时检查日志文件是否可写
+func checkLogWritable(logConfig config.Log) error {
+	if logConfig.Type != "file" && logConfig.Type != "all" {
+		return nil
+	}
+	file, err := os.OpenFile(logConfig.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("log file not writable: %w", err)
+	}
+	return file.Close()
+}
+
+// 通知后端的 webhook 主机，限流的后端取被包装的后端
+func notifierHost(notifier Notifier) (string, error) {
+	if limited, ok := notifier.(*rateLimitedNotifier); ok {
+		notifier = limited.Notifier
+	}
+	withHost, ok := notifier.(interface{ webhookHost() (string, error) })
+	if !ok {
+		return "", fmt.Errorf("unknown webhook host")
+	}
+	return withHost.webhookHost()
+}
+
+// 解析并连接主机
+func dialHost(host string) error {
+	conn, err := net.DialTimeout("tcp", host, dialTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
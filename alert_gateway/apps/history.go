@@ -0,0 +1,457 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	historyBucket      = []byte("alerts")
+	historyIndexBucket = []byte("index")
+)
+
+const (
+	// 默认保留 30 天的报警历史
+	defaultHistoryRetention = 30 * 24 * time.Hour
+	// 每条报警最多保留的投递结果，持续很久的报警每次重复通知都会增加投递结果
+	maxRecordDeliveries = 100
+	// 查询报警历史时每页默认和最多返回的数量
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// 报警状态的变化
+type StatusChange struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+// 一次投递的结果，result 为 success、held、queued、dead、failed 或 suppressed
+type DeliveryRecord struct {
+	Receiver string `json:"receiver,omitempty"`
+	Result   string `json:"result"`
+	// 投递失败的错误或报警被抑制的原因
+	Detail string    `json:"detail,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// 一次报警的历史记录，指纹相同但故障开始时间不同的报警是不同的记录
+type AlertRecord struct {
+	ID           uint64            `json:"id"`
+	Fingerprint  string            `json:"fingerprint"`
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	// 第一次和最后一次收到报警的时间，以及收到的次数
+	FirstSeen   time.Time        `json:"firstSeen"`
+	LastSeen    time.Time        `json:"lastSeen"`
+	Received    int              `json:"received"`
+	Transitions []StatusChange   `json:"transitions"`
+	Deliveries  []DeliveryRecord `json:"deliveries"`
+}
+
+// 报警在 [start, end] 期间是否处于故障状态，start 和 end 为零值时不限制
+func (r *AlertRecord) activeDuring(start, end time.Time, now time.Time) bool {
+	if !end.IsZero() && r.StartsAt.After(end) {
+		return false
+	}
+	until := now
+	if r.Status == "resolved" && !r.EndsAt.IsZero() {
+		until = r.EndsAt
+	}
+	return start.IsZero() || !until.Before(start)
+}
+
+// 根据收到的报警更新记录，状态变化时记录状态的变化
+func (r *AlertRecord) update(alert Alert, now time.Time) {
+	status := "firing"
+	if alert.Status == "resolved" {
+		status = "resolved"
+		r.EndsAt = parseAlertTime(alert.EndsAt, now)
+	}
+	if status != r.Status {
+		r.Status = status
+		r.Transitions = append(r.Transitions, StatusChange{Status: status, At: now})
+	}
+	r.Labels = alert.Labels
+	r.Annotations = alert.Annotations
+	r.GeneratorURL = alert.GeneratorURL
+	r.LastSeen = now
+	r.Received++
+}
+
+func (r *AlertRecord) addDeliveries(deliveries ...DeliveryRecord) {
+	r.Deliveries = append(r.Deliveries, deliveries...)
+	if len(r.Deliveries) > maxRecordDeliveries {
+		r.Deliveries = r.Deliveries[len(r.Deliveries)-maxRecordDeliveries:]
+	}
+}
+
+// 报警历史，保存在 bbolt 文件中，超过保留时间的记录定期删除
+type AlertHistory struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+// 打开报警历史
+func OpenHistory(cfg config.History) (*AlertHistory, error) {
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open history %s: %w", cfg.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{historyBucket, historyIndexBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init history %s: %w", cfg.Path, err)
+	}
+
+	h := &AlertHistory{db: db, retention: cfg.Retention}
+	if h.retention <= 0 {
+		h.retention = defaultHistoryRetention
+	}
+	return h, nil
+}
+
+func (h *AlertHistory) Close() error {
+	return h.db.Close()
+}
+
+// 记录收到的报警和每条报警的投递结果，deliveries 和 alerts 一一对应
+func (h *AlertHistory) Record(alerts []Alert, deliveries [][]DeliveryRecord) error {
+	now := time.Now()
+	return h.db.Update(func(tx *bolt.Tx) error {
+		for i, alert := range alerts {
+			record, err := getRecord(tx, alert)
+			if err != nil {
+				return err
+			}
+			if record == nil {
+				id, err := tx.Bucket(historyBucket).NextSequence()
+				if err != nil {
+					return err
+				}
+				record = &AlertRecord{
+					ID:          id,
+					Fingerprint: alertFingerprint(alert),
+					StartsAt:    parseAlertTime(alert.StartsAt, now),
+					FirstSeen:   now,
+				}
+				if err := tx.Bucket(historyIndexBucket).Put(historyKey(alert), itob(id)); err != nil {
+					return err
+				}
+			}
+			record.update(alert, now)
+			if i < len(deliveries) {
+				record.addDeliveries(deliveries[i]...)
+			}
+			if err := putRecord(tx, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 记录重试队列中的消息的投递结果，不修改报警的状态，报警的记录已经被删除时忽略
+func (h *AlertHistory) AddDelivery(alerts []Alert, delivery DeliveryRecord) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		for _, alert := range alerts {
+			record, err := getRecord(tx, alert)
+			if err != nil {
+				return err
+			}
+			if record == nil {
+				continue
+			}
+			record.addDeliveries(delivery)
+			if err := putRecord(tx, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 报警历史的查询条件，时间范围内处于故障状态的报警都会返回
+type HistoryQuery struct {
+	Matchers Matchers
+	Status   string
+	Start    time.Time
+	End      time.Time
+	Offset   int
+	Limit    int
+}
+
+// 一页查询结果，total 为满足条件的报警总数
+type HistoryPage struct {
+	Total  int            `json:"total"`
+	Offset int            `json:"offset"`
+	Limit  int            `json:"limit"`
+	Alerts []*AlertRecord `json:"alerts"`
+}
+
+// 查询报警历史，按收到报警的先后倒序返回
+func (h *AlertHistory) Query(q HistoryQuery) (*HistoryPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultHistoryLimit
+	}
+	if q.Limit > maxHistoryLimit {
+		q.Limit = maxHistoryLimit
+	}
+	page := &HistoryPage{Offset: q.Offset, Limit: q.Limit, Alerts: []*AlertRecord{}}
+	now := time.Now()
+	err := h.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var record AlertRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				logger.Errorf("Failed to decode history record %x: %v", k, err)
+				continue
+			}
+			if q.Status != "" && record.Status != q.Status {
+				continue
+			}
+			if !q.Matchers.Matches(record.Labels) || !record.activeDuring(q.Start, q.End, now) {
+				continue
+			}
+			if page.Total >= q.Offset && len(page.Alerts) < q.Limit {
+				page.Alerts = append(page.Alerts, &record)
+			}
+			page.Total++
+		}
+		return nil
+	})
+	return page, err
+}
+
+// 删除最后一次收到的时间超过保留时间的报警，返回删除的数量
+func (h *AlertHistory) Prune() (int, error) {
+	cutoff := time.Now().Add(-h.retention)
+	count := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		alerts := tx.Bucket(historyBucket)
+		index := tx.Bucket(historyIndexBucket)
+		// 遍历时不能删除，先找出需要删除的记录和索引，键复制出来避免删除后失效
+		expired := make(map[string]bool)
+		var keys []string
+		err := alerts.ForEach(func(k, v []byte) error {
+			var record AlertRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if record.LastSeen.Before(cutoff) {
+				expired[string(k)] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = index.ForEach(func(k, v []byte) error {
+			if expired[string(v)] || alerts.Get(v) == nil {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k := range expired {
+			if err := alerts.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		for _, k := range keys {
+			if err := index.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		count = len(expired)
+		return nil
+	})
+	return count, err
+}
+
+// 每小时删除一次过期的报警历史，直到 stop 被关闭
+func (h *AlertHistory) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if count, err := h.Prune(); err != nil {
+				logger.Errorf("Failed to prune alert history: %v", err)
+			} else if count > 0 {
+				logger.Infof("pruned %d alerts from history", count)
+			}
+		}
+	}
+}
+
+// 报警记录的索引，指纹和故障开始时间相同的报警是同一条记录
+func historyKey(alert Alert) []byte {
+	return []byte(alertFingerprint(alert) + "|" + formatAlertTime(parseAlertTime(alert.StartsAt, time.Time{})))
+}
+
+// 查找报警对应的记录，不存在时返回 nil
+func getRecord(tx *bolt.Tx, alert Alert) (*AlertRecord, error) {
+	id := tx.Bucket(historyIndexBucket).Get(historyKey(alert))
+	if id == nil {
+		return nil, nil
+	}
+	v := tx.Bucket(historyBucket).Get(id)
+	if v == nil {
+		return nil, nil
+	}
+	var record AlertRecord
+	if err := json.Unmarshal(v, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func putRecord(tx *bolt.Tx, record *AlertRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(historyBucket).Put(itob(record.ID), data)
+}
+
+// 解析报警中 RFC3339 格式的时间，为空或解析失败时返回 fallback
+func parseAlertTime(s string, fallback time.Time) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil || t.IsZero() {
+		return fallback
+	}
+	return t
+}
+
+func formatAlertTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// 把一次请求中每条报警的处理结果转换为投递结果
+func historyDeliveries(responses []map[string]interface{}) [][]DeliveryRecord {
+	now := time.Now()
+	deliveries := make([][]DeliveryRecord, len(responses))
+	for i, response := range responses {
+		if reason, ok := response["suppressed"].(string); ok {
+			deliveries[i] = []DeliveryRecord{{Result: "suppressed", Detail: reason, At: now}}
+			continue
+		}
+		for _, delivery := range response["deliveries"].([]map[string]interface{}) {
+			deliveries[i] = append(deliveries[i], deliveryRecordOf(delivery, now))
+		}
+	}
+	return deliveries
+}
+
+// 把 deliver 返回的发送结果转换为投递结果
+func deliveryRecordOf(delivery map[string]interface{}, at time.Time) DeliveryRecord {
+	record := DeliveryRecord{Result: "success", At: at}
+	record.Receiver, _ = delivery["receiver"].(string)
+	if err, ok := delivery["error"].(string); ok {
+		record.Result = "failed"
+		record.Detail = err
+	}
+	switch {
+	case delivery["queued"] == true:
+		record.Result = "queued"
+	case delivery["dead"] == true:
+		record.Result = "dead"
+	case delivery["held"] == true:
+		record.Result = "held"
+	}
+	return record
+}
+
+// 记录收到的报警和投递结果，记录失败不影响报警的发送
+func (app *App) recordHistory(alerts []Alert, responses []map[string]interface{}) {
+	if app.history == nil {
+		return
+	}
+	if err := app.history.Record(alerts, historyDeliveries(responses)); err != nil {
+		logger.Errorf("Failed to record alert history: %v", err)
+	}
+}
+
+// 查询报警历史
+// GET /api/alerts?filter=instance="10.10.1.21"&status=firing&start=2024-05-01T00:00:00Z&end=2024-05-02T00:00:00Z&limit=100&offset=0
+// filter 可以传多个，格式和 alertmanager 的匹配器一致，start 和 end 为 RFC3339 格式的时间
+func (app *App) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := app.history.Query(query)
+	if err != nil {
+		logger.Errorf("Failed to query alert history: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func parseHistoryQuery(r *http.Request) (HistoryQuery, error) {
+	var q HistoryQuery
+	params := r.URL.Query()
+	for _, filter := range params["filter"] {
+		m, err := ParseMatcher(filter)
+		if err != nil {
+			return q, err
+		}
+		q.Matchers = append(q.Matchers, m)
+	}
+	switch q.Status = params.Get("status"); q.Status {
+	case "", "firing", "resolved":
+	default:
+		return q, fmt.Errorf("status must be firing or resolved, got %q", q.Status)
+	}
+	for name, t := range map[string]*time.Time{"start": &q.Start, "end": &q.End} {
+		if value := params.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s: %v", name, err)
+			}
+			*t = parsed
+		}
+	}
+	for name, n := range map[string]*int{"limit": &q.Limit, "offset": &q.Offset} {
+		if value := params.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return q, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*n = parsed
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,169 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 源报警没有 endsAt 时，最后一次收到之后继续抑制的时间
+const defaultInhibitSourceTTL = 24 * time.Hour
+
+// 一条抑制规则，由 config.InhibitRule 编译而来
+type inhibitRule struct {
+	source Matchers
+	target Matchers
+	equal  []string
+}
+
+// 源报警是否抑制目标报警，equal 中的标签值需要相同，报警不会抑制自己
+func (r *inhibitRule) inhibits(source, target map[string]string) bool {
+	if !r.target.Matches(target) {
+		return false
+	}
+	for _, name := range r.equal {
+		if source[name] != target[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// 根据抑制规则抑制报警
+// 记录正在触发并且匹配某条规则源条件的报警，恢复时删除
+// 源报警的恢复通知丢失时，在 endsAt 之后停止抑制，没有 endsAt 时在最后一次收到后 ttl 停止抑制
+type Inhibitor struct {
+	rules []*inhibitRule
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	sources map[string]inhibitSource // 正在触发的源报警，按指纹保存
+}
+
+// 正在触发的源报警的标签和停止抑制的时间
+type inhibitSource struct {
+	labels  map[string]string
+	expires time.Time
+}
+
+func NewInhibitor(rules []config.InhibitRule, ttl time.Duration) (*Inhibitor, error) {
+	if ttl <= 0 {
+		ttl = defaultInhibitSourceTTL
+	}
+	inhibitor := &Inhibitor{ttl: ttl, sources: make(map[string]inhibitSource)}
+	for i, rule := range rules {
+		source, err := labelMatchers(rule.SourceMatch, rule.SourceMatchRE)
+		if err != nil {
+			return nil, fmt.Errorf("inhibit_rules[%d] source: %w", i, err)
+		}
+		target, err := labelMatchers(rule.TargetMatch, rule.TargetMatchRE)
+		if err != nil {
+			return nil, fmt.Errorf("inhibit_rules[%d] target: %w", i, err)
+		}
+		inhibitor.rules = append(inhibitor.rules, &inhibitRule{source: source, target: target, equal: rule.Equal})
+	}
+	return inhibitor, nil
+}
+
+// 把 match 和 match_re 转换为匹配器，按标签名排序
+func labelMatchers(match, matchRE map[string]string) (Matchers, error) {
+	var matchers Matchers
+	for name, value := range match {
+		m, err := NewMatcher(name, value, false, true)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	for name, value := range matchRE {
+		m, err := NewMatcher(name, value, true, true)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	sort.Slice(matchers, func(i, j int) bool {
+		return matchers[i].Name < matchers[j].Name
+	})
+	return matchers, nil
+}
+
+// 是否有规则的源条件匹配标签
+func (i *Inhibitor) isSource(labels map[string]string) bool {
+	for _, rule := range i.rules {
+		if rule.source.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// 记录收到的报警，故障的源报警开始抑制，恢复后停止抑制
+func (i *Inhibitor) Observe(alert Alert) {
+	if !i.isSource(alert.Labels) {
+		return
+	}
+	fingerprint := alertFingerprint(alert)
+	now := time.Now()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.sweep(now)
+	if alert.Status == "resolved" {
+		delete(i.sources, fingerprint)
+		return
+	}
+	// alertmanager webhook 中故障报警的 endsAt 为零值，prometheus 推送的 endsAt 为几次计算间隔之后
+	expires := now.Add(i.ttl)
+	if endsAt, err := time.Parse(time.RFC3339, alert.EndsAt); err == nil && endsAt.After(now) {
+		expires = endsAt
+	}
+	i.sources[fingerprint] = inhibitSource{labels: alert.Labels, expires: expires}
+}
+
+// 删除已经过期的源报警，调用时需要持有锁
+func (i *Inhibitor) sweep(now time.Time) {
+	for fingerprint, source := range i.sources {
+		if !now.Before(source.expires) {
+			delete(i.sources, fingerprint)
+		}
+	}
+}
+
+// 返回抑制报警的源报警的指纹，没有被抑制时返回空字符串
+func (i *Inhibitor) Inhibits(alert Alert) string {
+	fingerprint := alertFingerprint(alert)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.sweep(time.Now())
+	for _, rule := range i.rules {
+		for sourceFingerprint, source := range i.sources {
+			if sourceFingerprint != fingerprint && rule.source.Matches(source.labels) && rule.inhibits(source.labels, alert.Labels) {
+				return sourceFingerprint
+			}
+		}
+	}
+	return ""
+}
+
+// 正在抑制其他报警的源报警数量
+func (i *Inhibitor) Sources() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.sweep(time.Now())
+	return len(i.sources)
+}
+
+// 重新加载配置后继续使用旧的抑制器记录的源报警，不再匹配任何规则的源报警被丢弃
+func (i *Inhibitor) inherit(old *Inhibitor) {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for fingerprint, source := range old.sources {
+		if i.isSource(source.labels) {
+			i.sources[fingerprint] = source
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package apps
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 标签匹配器，和 alertmanager 的匹配器一致，支持 = != =~ !~ 四种操作
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+
+	re *regexp.Regexp
+}
+
+// 创建匹配器，正则匹配器需要匹配整个标签值
+func NewMatcher(name, value string, isRegex, isEqual bool) (*Matcher, error) {
+	m := &Matcher{Name: name, Value: value, IsRegex: isRegex, IsEqual: isEqual}
+	if err := m.compile(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// 解析 name=value、name!=value、name=~regex、name!~regex 格式的匹配器，值可以用双引号包起来
+func ParseMatcher(s string) (*Matcher, error) {
+	for _, op := range []string{"=~", "!~", "!=", "="} {
+		i := strings.Index(s, op)
+		if i <= 0 {
+			continue
+		}
+		name := strings.TrimSpace(s[:i])
+		value := strings.TrimSpace(s[i+len(op):])
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		return NewMatcher(name, value, op == "=~" || op == "!~", op == "=" || op == "=~")
+	}
+	return nil, fmt.Errorf("invalid matcher %q", s)
+}
+
+func (m *Matcher) compile() error {
+	if m.Name == "" {
+		return fmt.Errorf("matcher name must be provided")
+	}
+	if !m.IsRegex {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + m.Value + ")$")
+	if err != nil {
+		return fmt.Errorf("matcher %s: %w", m.Name, err)
+	}
+	m.re = re
+	return nil
+}
+
+// 判断标签是否匹配，标签不存在时按空字符串匹配
+func (m *Matcher) Matches(labels map[string]string) bool {
+	value := labels[m.Name]
+	var matched bool
+	if m.IsRegex {
+		matched = m.re.MatchString(value)
+	} else {
+		matched = value == m.Value
+	}
+	return matched == m.IsEqual
+}
+
+func (m *Matcher) String() string {
+	op := "="
+	switch {
+	case m.IsRegex && m.IsEqual:
+		op = "=~"
+	case m.IsRegex:
+		op = "!~"
+	case !m.IsEqual:
+		op = "!="
+	}
+	return fmt.Sprintf("%s%s%q", m.Name, op, m.Value)
+}
+
+// 从json解析时 isEqual 默认为 true，并编译正则
+func (m *Matcher) UnmarshalJSON(data []byte) error {
+	type plain Matcher
+	aux := struct {
+		*plain
+		IsEqual *bool `json:"isEqual"`
+	}{plain: (*plain)(m)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	m.IsEqual = aux.IsEqual == nil || *aux.IsEqual
+	return m.compile()
+}
+
+// 一组匹配器，所有匹配器都匹配时才算匹配
+type Matchers []*Matcher
+
+func (ms Matchers) Matches(labels map[string]string) bool {
+	for _, m := range ms {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
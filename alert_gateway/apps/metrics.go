@@ -0,0 +1,118 @@
+package apps
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 网关自身的监控指标
+var (
+	webhooksReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "alert_gateway_webhooks_received_total",
+		Help: "Number of webhook requests received",
+	})
+	alertsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_gateway_alerts_received_total",
+		Help: "Number of alerts received by status and alertname",
+	}, []string{"status", "alertname"})
+	alertsSuppressed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_gateway_alerts_suppressed_total",
+		Help: "Number of alerts suppressed by reason",
+	}, []string{"reason"})
+	deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_gateway_deliveries_total",
+		Help: "Number of message deliveries by receiver and result",
+	}, []string{"receiver", "result"})
+	authRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_gateway_auth_rejected_total",
+		Help: "Number of requests rejected by authentication by reason",
+	}, []string{"reason"})
+	dingdingRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "alert_gateway_dingding_request_duration_seconds",
+		Help:    "Latency of DingTalk robot API requests",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"receiver"})
+	reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_gateway_config_reloads_total",
+		Help: "Number of config reloads by result",
+	}, []string{"result"})
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alert_gateway_last_success_timestamp_seconds",
+		Help: "Unix timestamp of the last successful delivery by receiver",
+	}, []string{"receiver"})
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alert_gateway_queue_depth",
+		Help: "Number of messages in the retry queue by state",
+	}, []string{"state"})
+	rateLimitTokens = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alert_gateway_ratelimit_tokens",
+		Help: "Tokens available in the rate limiter by receiver",
+	}, []string{"receiver"})
+	rateLimitHeld = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alert_gateway_ratelimit_held_messages",
+		Help: "Messages held by the rate limiter waiting for a digest by receiver",
+	}, []string{"receiver"})
+	rateLimitDigests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_gateway_ratelimit_digests_total",
+		Help: "Number of digest messages sent by the rate limiter by receiver",
+	}, []string{"receiver"})
+	flappingAlerts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "alert_gateway_flapping_alerts",
+		Help: "Number of alerts currently flapping",
+	})
+)
+
+// 注册监控指标
+func init() {
+	prometheus.MustRegister(webhooksReceived)
+	prometheus.MustRegister(alertsReceived)
+	prometheus.MustRegister(alertsSuppressed)
+	prometheus.MustRegister(deliveries)
+	prometheus.MustRegister(authRejected)
+	prometheus.MustRegister(dingdingRequestDuration)
+	prometheus.MustRegister(reloads)
+	prometheus.MustRegister(lastSuccess)
+	prometheus.MustRegister(queueDepth)
+	prometheus.MustRegister(rateLimitTokens)
+	prometheus.MustRegister(rateLimitHeld)
+	prometheus.MustRegister(rateLimitDigests)
+	prometheus.MustRegister(flappingAlerts)
+}
+
+// 记录一次投递的结果
+func recordDelivery(receiver, result string) {
+	deliveries.WithLabelValues(receiver, result).Inc()
+	if result == "success" {
+		lastSuccess.WithLabelValues(receiver).Set(float64(time.Now().Unix()))
+	}
+}
+
+// 采集时更新队列、限流器和抖动检测的状态
+func (app *App) updateMetrics() {
+	if app.queue != nil {
+		pending, dead := app.queue.Depth()
+		queueDepth.WithLabelValues("pending").Set(float64(pending))
+		queueDepth.WithLabelValues("dead").Set(float64(dead))
+	}
+	for _, stats := range app.rateLimitStats() {
+		rateLimitTokens.WithLabelValues(stats.Receiver).Set(stats.Tokens)
+		rateLimitHeld.WithLabelValues(stats.Receiver).Set(float64(stats.Held))
+	}
+	if flapping := app.current().flapping; flapping != nil {
+		flappingAlerts.Set(float64(flapping.Count()))
+	} else {
+		flappingAlerts.Set(0)
+	}
+}
+
+// 暴露监控指标，GET /metrics
+func (app *App) handleMetrics() http.Handler {
+	handler := promhttp.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.updateMetrics()
+		handler.ServeHTTP(w, r)
+	})
+}
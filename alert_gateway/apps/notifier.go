@@ -0,0 +1,278 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// 渲染后的报警消息，交给通知后端发送
+type Message struct {
+	Title   string  `json:"title"`   // 消息标题，如 故障/恢复
+	Content string  `json:"content"` // 渲染后的消息内容
+	Type    string  `json:"type"`    // 消息类型 markdown、text、actionCard 或 feedCard
+	Status  string  `json:"status"`  // 报警状态 firing 或 resolved
+	Alerts  []Alert `json:"alerts"`  // 消息对应的原始报警
+	// alertmanager 的地址，用于生成静默页面的链接
+	ExternalURL string `json:"externalURL,omitempty"`
+	// feedCard 汇总消息中合并的消息
+	Items []*Message `json:"items,omitempty"`
+	// 报警升级时额外@的人，只支持钉钉
+	Mentions *DingTalkAt `json:"mentions,omitempty"`
+}
+
+// 一次投递的结果
+type Result struct {
+	Receiver   string `json:"receiver"`
+	StatusCode int    `json:"statusCode"`
+	RespMsg    string `json:"respMsg"`
+	// 钉钉、企业微信响应中的错误码和错误信息
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg,omitempty"`
+	// 被限流器保存，稍后合并到汇总消息中发送
+	Held bool `json:"held"`
+}
+
+// 接口返回非2xx状态码时的错误
+type HTTPStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.Body)
+}
+
+// 判断错误是否为重试也不会成功的错误
+func isPermanent(err error) bool {
+	var permanent interface{ Permanent() bool }
+	return errors.As(err, &permanent) && permanent.Permanent()
+}
+
+// 错误转换为字符串，避免 error 接口在json中被序列化为 {}
+func errorString(err error) interface{} {
+	if err == nil {
+		return nil
+	}
+	return err.Error()
+}
+
+// 通知后端接口，钉钉、企业微信、飞书、Slack和通用webhook都实现这个接口
+type Notifier interface {
+	// 接收者名称
+	Name() string
+	// 接收者期望的消息类型 markdown、text 或 actionCard
+	MessageType() string
+	// 接收者指定的消息模板，为空时按消息类型选择模板
+	Template() string
+	// 消息内容的最大字节数，0 表示不限制
+	MaxMessageSize() int
+	// 发送一条渲染好的消息并返回投递结果
+	Send(msg *Message) (*Result, error)
+}
+
+// 通知后端共用的接收者配置
+type receiverBase struct {
+	receiver config.Receiver
+}
+
+func (b *receiverBase) Name() string {
+	return b.receiver.Name
+}
+
+func (b *receiverBase) MessageType() string {
+	return b.receiver.MessageType
+}
+
+func (b *receiverBase) Template() string {
+	return b.receiver.Template
+}
+
+func (b *receiverBase) MaxMessageSize() int {
+	if b.receiver.MaxMessageSize > 0 {
+		return b.receiver.MaxMessageSize
+	}
+	return defaultMaxMessageSize[b.receiver.Type]
+}
+
+// webhook 地址中的主机和端口，用于就绪检查
+func (b *receiverBase) webhookHost() (string, error) {
+	u, err := url.Parse(b.receiver.WebhookURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid webhook url %q", b.receiver.WebhookURL)
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "http" {
+		return net.JoinHostPort(u.Hostname(), "80"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "443"), nil
+}
+
+// 各个后端的消息长度限制
+var defaultMaxMessageSize = map[string]int{
+	"dingding": 20000,
+	"wecom":    4096,
+	"feishu":   30000,
+	"lark":     30000,
+	"slack":    3000,
+}
+
+// 所有后端共用的http客户端
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// 根据接收者配置创建对应的通知后端
+func NewNotifier(receiver config.Receiver) (Notifier, error) {
+	if receiver.Name == "" {
+		return nil, fmt.Errorf("receiver name must be provided")
+	}
+	if receiver.Type == "" {
+		receiver.Type = "dingding"
+	}
+	switch receiver.Type {
+	case "dingding":
+		return newDingTalk(receiver)
+	case "wecom":
+		return newWeCom(receiver)
+	case "feishu", "lark":
+		return newFeishu(receiver)
+	case "slack":
+		return newSlack(receiver)
+	case "webhook":
+		return newWebhook(receiver)
+	default:
+		return nil, fmt.Errorf("receiver %s: unknown type %q", receiver.Name, receiver.Type)
+	}
+}
+
+// 根据配置创建所有的通知后端
+// 没有配置 [[receivers]] 时，使用 [app] 中的 webhook_url/token/secret 创建一个名为 default 的钉钉接收者
+// 限流的令牌桶保存在 buckets 中，重新加载配置时继续使用，钉钉接收者从 schedules 中查找当前的值班人员
+func buildNotifiers(cfg *config.Config, buckets map[string]*tokenBucket, schedules Schedules) (map[string]Notifier, error) {
+	receivers := cfg.Receivers
+	if len(receivers) == 0 {
+		receivers = []config.Receiver{legacyReceiver(cfg)}
+	}
+
+	notifiers := make(map[string]Notifier)
+	for _, receiver := range receivers {
+		if receiver.MessageType == "" {
+			receiver.MessageType = appMessageType(cfg)
+		}
+		if receiver.Type == "" {
+			receiver.Type = "dingding"
+		}
+		notifier, err := NewNotifier(receiver)
+		if err != nil {
+			return nil, err
+		}
+		if dingTalk, ok := notifier.(*DingTalk); ok {
+			dingTalk.schedules = schedules
+		}
+		if _, ok := notifiers[receiver.Name]; ok {
+			return nil, fmt.Errorf("duplicate receiver name %s", receiver.Name)
+		}
+		notifiers[receiver.Name] = withRateLimit(receiver, notifier, buckets)
+	}
+	return notifiers, nil
+}
+
+// 兼容旧的配置方式，从 [app] 中读取钉钉的配置
+func legacyReceiver(cfg *config.Config) config.Receiver {
+	receiver := config.Receiver{Name: "default", Type: "dingding"}
+	receiver.WebhookURL = cfg.App.WebhookURL
+	receiver.Token = cfg.App.Token
+	receiver.Secret = cfg.App.Secret
+	return receiver
+}
+
+// 读取 [app] 中配置的默认消息类型
+func appMessageType(cfg *config.Config) string {
+	if cfg.App.MessageType == "" {
+		return "markdown" // 默认消息类型
+	}
+	return cfg.App.MessageType
+}
+
+// 以json格式POST数据到指定地址，返回响应状态码和响应内容
+func postJSON(url string, data []byte, headers map[string]string) (int, string, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		err = redactURLError(err)
+		logger.Errorf("Failed to create request: %v", err)
+		return 0, "", err
+	}
+	// 设置请求头
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		err = redactURLError(err)
+		logger.Errorf("Failed to send request: %v", err)
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Errorf("Failed to read response body: %v", err)
+		return resp.StatusCode, "", err
+	}
+	return resp.StatusCode, string(respBody), nil
+}
+
+// 请求失败的错误中包含完整的地址，钉钉和企业微信的 token 在参数中，飞书的 token 在路径中，只保留协议和主机
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	if u, perr := url.Parse(urlErr.URL); perr == nil {
+		urlErr.URL = u.Scheme + "://" + u.Host + "/<redacted>"
+	} else {
+		urlErr.URL = "<redacted>"
+	}
+	return err
+}
+
+// 检查投递结果，HTTP 状态码不是 2xx，或者响应中的 errcode（钉钉、企业微信）/code（飞书）不为 0 时返回错误
+func checkResult(result *Result) error {
+	if result.Held {
+		return nil
+	}
+	if result.StatusCode < 200 || result.StatusCode >= 300 {
+		return fmt.Errorf("receiver %s: %w", result.Receiver, &HTTPStatusError{StatusCode: result.StatusCode, Body: result.RespMsg})
+	}
+	var resp struct {
+		ErrCode *int   `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Code    *int   `json:"code"`
+		Msg     string `json:"msg"`
+	}
+	if err := json.Unmarshal([]byte(result.RespMsg), &resp); err != nil {
+		// Slack 和通用 webhook 的响应不一定是json
+		return nil
+	}
+	if resp.ErrCode != nil && *resp.ErrCode != 0 {
+		return fmt.Errorf("receiver %s: errcode %d: %s", result.Receiver, *resp.ErrCode, resp.ErrMsg)
+	}
+	if resp.Code != nil && *resp.Code != 0 {
+		return fmt.Errorf("receiver %s: code %d: %s", result.Receiver, *resp.Code, resp.Msg)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pendingBucket = []byte("pending")
+	deadBucket    = []byte("dead")
+)
+
+// 重试队列中的一条消息
+type QueueEntry struct {
+	ID          uint64    `json:"id"`
+	Receiver    string    `json:"receiver"`
+	Message     *Message  `json:"message"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// 发送失败的消息保存在 bbolt 文件中，按指数退避加随机抖动重试，进程重启后继续重试
+// 超过最大重试次数的消息移到死信中，可以通过接口查看和重新投递
+type RetryQueue struct {
+	db             *bolt.DB
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	send           func(entry *QueueEntry) error
+}
+
+// 打开重试队列，send 用于重新发送队列中的消息
+func OpenRetryQueue(cfg config.Queue, send func(entry *QueueEntry) error) (*RetryQueue, error) {
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open queue %s: %w", cfg.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{pendingBucket, deadBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init queue %s: %w", cfg.Path, err)
+	}
+
+	q := &RetryQueue{
+		db:             db,
+		maxAttempts:    cfg.MaxAttempts,
+		initialBackoff: cfg.InitialBackoff,
+		maxBackoff:     cfg.MaxBackoff,
+		send:           send,
+	}
+	if q.maxAttempts <= 0 {
+		q.maxAttempts = 10
+	}
+	if q.initialBackoff <= 0 {
+		q.initialBackoff = 5 * time.Second
+	}
+	if q.maxBackoff <= 0 {
+		q.maxBackoff = 10 * time.Minute
+	}
+	return q, nil
+}
+
+func (q *RetryQueue) Close() error {
+	return q.db.Close()
+}
+
+// 把第一次发送失败的消息放入队列
+func (q *RetryQueue) Enqueue(receiver string, msg *Message, sendErr error) (*QueueEntry, error) {
+	now := time.Now()
+	entry := &QueueEntry{
+		Receiver:  receiver,
+		Message:   msg,
+		Attempts:  1,
+		LastError: sendErr.Error(),
+		CreatedAt: now,
+	}
+	entry.NextAttempt = now.Add(q.backoff(entry.Attempts))
+
+	// 重试也不会成功的错误直接放入死信
+	permanent := isPermanent(sendErr)
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		id, err := tx.Bucket(pendingBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+		if permanent {
+			return putEntry(tx.Bucket(deadBucket), entry)
+		}
+		return putEntry(tx.Bucket(pendingBucket), entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if permanent {
+		logger.Errorf("message %d to %s dead-lettered: %v", entry.ID, receiver, sendErr)
+	} else {
+		logger.Infof("queued message %d to %s, next attempt at %s", entry.ID, receiver, entry.NextAttempt.Format(time.RFC3339))
+	}
+	return entry, nil
+}
+
+// 定时检查队列并重试到期的消息，直到 stop 被关闭
+func (q *RetryQueue) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			q.retryDue(stop)
+		}
+	}
+}
+
+// 重试所有到期的消息，stop 被关闭时不再重试剩下的消息
+func (q *RetryQueue) retryDue(stop <-chan struct{}) {
+	now := time.Now()
+	var due []*QueueEntry
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).ForEach(func(k, v []byte) error {
+			var entry QueueEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				logger.Errorf("Failed to decode queue entry %x: %v", k, err)
+				return nil
+			}
+			if !entry.NextAttempt.After(now) {
+				due = append(due, &entry)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Errorf("Failed to read queue: %v", err)
+		return
+	}
+
+	for _, entry := range due {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		sendErr := q.send(entry)
+		err := q.db.Update(func(tx *bolt.Tx) error {
+			pending := tx.Bucket(pendingBucket)
+			if sendErr == nil {
+				logger.Infof("queued message %d to %s delivered after %d attempts", entry.ID, entry.Receiver, entry.Attempts+1)
+				return pending.Delete(itob(entry.ID))
+			}
+
+			entry.Attempts++
+			entry.LastError = sendErr.Error()
+			if entry.Attempts >= q.maxAttempts || isPermanent(sendErr) {
+				logger.Errorf("queued message %d to %s dead-lettered after %d attempts: %v", entry.ID, entry.Receiver, entry.Attempts, sendErr)
+				if err := pending.Delete(itob(entry.ID)); err != nil {
+					return err
+				}
+				return putEntry(tx.Bucket(deadBucket), entry)
+			}
+			entry.NextAttempt = time.Now().Add(q.backoff(entry.Attempts))
+			logger.Errorf("queued message %d to %s failed %d times, next attempt at %s: %v", entry.ID, entry.Receiver, entry.Attempts, entry.NextAttempt.Format(time.RFC3339), sendErr)
+			return putEntry(pending, entry)
+		})
+		if err != nil {
+			logger.Errorf("Failed to update queue entry %d: %v", entry.ID, err)
+		}
+	}
+}
+
+// 计算第 attempts 次失败后的等待时间，按指数增长并在 [backoff/2, backoff] 之间随机抖动
+func (q *RetryQueue) backoff(attempts int) time.Duration {
+	backoff := q.initialBackoff
+	for i := 1; i < attempts && backoff < q.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > q.maxBackoff {
+		backoff = q.maxBackoff
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// 列出队列中的消息，state 为 pending 或 dead
+func (q *RetryQueue) List(state string) ([]*QueueEntry, error) {
+	name, err := bucketName(state)
+	if err != nil {
+		return nil, err
+	}
+	entries := []*QueueEntry{}
+	err = q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(name).ForEach(func(k, v []byte) error {
+			var entry QueueEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, &entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// 队列中等待重试和死信的消息数量
+func (q *RetryQueue) Depth() (pending, dead int) {
+	q.db.View(func(tx *bolt.Tx) error {
+		pending = tx.Bucket(pendingBucket).Stats().KeyN
+		dead = tx.Bucket(deadBucket).Stats().KeyN
+		return nil
+	})
+	return pending, dead
+}
+
+// 把死信重新放回队列立即重试，id 为 0 时重新投递所有死信，返回重新投递的数量
+func (q *RetryQueue) Replay(id uint64) (int, error) {
+	count := 0
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		dead := tx.Bucket(deadBucket)
+		pending := tx.Bucket(pendingBucket)
+
+		var entries []*QueueEntry
+		if id != 0 {
+			v := dead.Get(itob(id))
+			if v == nil {
+				return fmt.Errorf("dead letter %d not found", id)
+			}
+			var entry QueueEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, &entry)
+		} else {
+			err := dead.ForEach(func(k, v []byte) error {
+				var entry QueueEntry
+				if err := json.Unmarshal(v, &entry); err != nil {
+					return err
+				}
+				entries = append(entries, &entry)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range entries {
+			if err := dead.Delete(itob(entry.ID)); err != nil {
+				return err
+			}
+			entry.Attempts = 0
+			entry.NextAttempt = time.Now()
+			if err := putEntry(pending, entry); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func bucketName(state string) ([]byte, error) {
+	switch state {
+	case "pending", "":
+		return pendingBucket, nil
+	case "dead":
+		return deadBucket, nil
+	default:
+		return nil, fmt.Errorf("unknown queue state %s", state)
+	}
+}
+
+func putEntry(bucket *bolt.Bucket, entry *QueueEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(itob(entry.ID), data)
+}
+
+// 把 id 转换为大端字节序，保证 bbolt 中按 id 顺序遍历
+func itob(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+// 查看重试队列，GET /api/queue?state=pending|dead
+func (app *App) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	entries, err := app.queue.List(r.URL.Query().Get("state"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// 重新投递死信，POST /api/queue/replay?id=1，不传 id 时重新投递所有死信
+func (app *App) handleQueueReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var id uint64
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		var err error
+		if id, err = strconv.ParseUint(idStr, 10, 64); err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+	}
+	count, err := app.queue.Replay(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.Infof("replayed %d dead letters", count)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"replayed": count})
+}
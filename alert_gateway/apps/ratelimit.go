@@ -0,0 +1,309 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 各个后端每分钟允许发送的消息数量，钉钉和企业微信的机器人都限制每分钟20条
+var defaultRateLimit = map[string]int{
+	"dingding": 20,
+	"wecom":    20,
+}
+
+// 令牌桶，capacity 为桶的容量，rate 为每秒补充的令牌数
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	rate     float64
+	last     time.Time
+}
+
+// 创建每分钟最多发送 limit 条消息的令牌桶，每秒补充 limit/60 个令牌，容量为 burst
+// burst 为空闲后可以连续发送的消息数量，不配置时为 limit 的一半
+func newTokenBucket(limit, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = limit / 2
+	}
+	if burst > limit {
+		burst = limit
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		capacity: float64(burst),
+		tokens:   float64(burst),
+		rate:     float64(limit) / 60,
+		last:     time.Now(),
+	}
+}
+
+// 补充令牌，调用方需要持有锁
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// 尝试取出一个令牌
+func (b *tokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 当前可用的令牌数
+func (b *tokenBucket) available() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return b.tokens
+}
+
+// 带限流的通知后端，超过限制的消息先保存起来，有令牌时合并成一条汇总消息发送
+type rateLimitedNotifier struct {
+	Notifier
+	bucket *tokenBucket
+	// 汇总消息的类型，为 feedCard 时每条消息作为一个链接发送
+	digestType string
+
+	mu      sync.Mutex
+	held    []*Message
+	digests uint64 // 发送的汇总消息数量
+	merged  uint64 // 合并到汇总消息中的消息数量
+	// 汇总消息发送失败时调用，用于放入重试队列
+	onFailure func(receiver string, msg *Message, err error)
+	// 重新加载配置后不为 nil，收到的消息不再保存，转交给新的配置发送
+	forward func(msg *Message) (*Result, error)
+}
+
+// 限流器的状态
+type RateLimitStats struct {
+	Receiver string  `json:"receiver"`
+	Tokens   float64 `json:"tokens"`
+	Held     int     `json:"held"`
+	Digests  uint64  `json:"digests"`
+	Merged   uint64  `json:"merged"`
+}
+
+// 有令牌并且没有等待合并的消息时直接发送，否则保存消息等待合并发送
+func (n *rateLimitedNotifier) Send(msg *Message) (*Result, error) {
+	n.mu.Lock()
+	if forward := n.forward; forward != nil {
+		n.mu.Unlock()
+		return forward(msg)
+	}
+	if len(n.held) == 0 && n.bucket.take() {
+		n.mu.Unlock()
+		result, err := n.Notifier.Send(msg)
+		// 其他程序也在使用同一个机器人时仍然可能被钉钉限流，这时也保存消息等待合并发送
+		if !errors.Is(err, ErrDingTalkRateLimited) {
+			return result, err
+		}
+		n.mu.Lock()
+	}
+	n.held = append(n.held, msg)
+	held := len(n.held)
+	n.mu.Unlock()
+
+	logger.Infof("receiver %s is rate limited, %d messages held for digest", n.Name(), held)
+	return &Result{Receiver: n.Name(), RespMsg: "held by rate limiter", Held: true}, nil
+}
+
+// 有令牌时把保存的消息合并成一条汇总消息发送
+func (n *rateLimitedNotifier) flush() {
+	n.mu.Lock()
+	if len(n.held) == 0 || !n.bucket.take() {
+		n.mu.Unlock()
+		return
+	}
+	digest, count := newDigest(n.held, n.MaxMessageSize())
+	if count > 1 && n.digestType == "feedCard" {
+		digest.Type = "feedCard"
+		digest.Items = append([]*Message(nil), n.held[:count]...)
+	}
+	n.held = n.held[count:]
+	if count > 1 {
+		n.digests++
+		n.merged += uint64(count)
+		rateLimitDigests.WithLabelValues(n.Name()).Inc()
+	}
+	n.mu.Unlock()
+
+	logger.Infof("sending digest of %d messages to %s", count, n.Name())
+	result, err := n.Notifier.Send(digest)
+	if err == nil {
+		err = checkResult(result)
+	}
+	if err != nil {
+		logger.Errorf("Failed to send digest to %s: %v", n.Name(), err)
+		recordDelivery(n.Name(), "failed")
+		if n.onFailure != nil {
+			n.onFailure(n.Name(), digest, err)
+		}
+		return
+	}
+	recordDelivery(n.Name(), "success")
+}
+
+// 重新加载配置时停止保存消息，之后收到的消息交给 forward 发送，返回已经保存的消息
+// 重新加载前开始处理的请求仍然可能使用旧的限流器，这些消息不会留在旧的限流器中丢失
+func (n *rateLimitedNotifier) retire(forward func(msg *Message) (*Result, error)) []*Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.forward = forward
+	held := n.held
+	n.held = nil
+	return held
+}
+
+// 取出所有等待合并的消息
+func (n *rateLimitedNotifier) drain() []*Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	held := n.held
+	n.held = nil
+	return held
+}
+
+func (n *rateLimitedNotifier) stats() RateLimitStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return RateLimitStats{
+		Receiver: n.Name(),
+		Tokens:   n.bucket.available(),
+		Held:     len(n.held),
+		Digests:  n.digests,
+		Merged:   n.merged,
+	}
+}
+
+// 把保存的消息合并成一条汇总消息，返回汇总消息和合并的消息数量
+// 只有一条消息时原样发送，合并后超过长度限制时剩下的消息留给下一条汇总消息
+func newDigest(held []*Message, maxSize int) (*Message, int) {
+	if len(held) == 1 {
+		return held[0], 1
+	}
+
+	separator := "\n\n---\n\n"
+	if held[0].Type == "text" {
+		separator = "\n\n"
+	}
+
+	var contents []string
+	var alerts []Alert
+	var mentions *DingTalkAt
+	status := "resolved"
+	size := 0
+	count := 0
+	for _, msg := range held {
+		if count > 0 && maxSize > 0 && size+len(separator)+len(msg.Content)+64 > maxSize {
+			break
+		}
+		contents = append(contents, msg.Content)
+		alerts = append(alerts, msg.Alerts...)
+		if msg.Mentions != nil {
+			// 报警升级的消息被合并时仍然@升级的人
+			if mentions == nil {
+				mentions = &DingTalkAt{}
+			}
+			mentions.merge(msg.Mentions)
+		}
+		if msg.Status != "resolved" {
+			status = "firing"
+		}
+		size += len(separator) + len(msg.Content)
+		count++
+	}
+
+	title := fmt.Sprintf("报警汇总(%d)", count)
+	header := fmt.Sprintf("# %s\n\n限流期间合并了 %d 条消息%s", title, count, separator)
+	if held[0].Type == "text" {
+		header = fmt.Sprintf("%s\n限流期间合并了 %d 条消息%s", title, count, separator)
+	}
+	return &Message{
+		Title:       title,
+		Content:     header + strings.Join(contents, separator),
+		Type:        held[0].Type,
+		Status:      status,
+		Alerts:      alerts,
+		ExternalURL: held[0].ExternalURL,
+		Mentions:    mentions,
+	}, count
+}
+
+// 接收者配置了限流时给通知后端加上限流，同一个机器人（类型、webhook地址和token相同）并且限流配置相同的接收者共用一个令牌桶
+func withRateLimit(receiver config.Receiver, notifier Notifier, buckets map[string]*tokenBucket) Notifier {
+	limit := receiver.RateLimit
+	if limit == 0 {
+		limit = defaultRateLimit[receiver.Type]
+	}
+	if limit <= 0 {
+		return notifier
+	}
+	key := fmt.Sprintf("%s|%s|%s|%d|%d", receiver.Type, receiver.WebhookURL, string(receiver.Token), limit, receiver.RateBurst)
+	bucket, ok := buckets[key]
+	if !ok {
+		bucket = newTokenBucket(limit, receiver.RateBurst)
+		buckets[key] = bucket
+	}
+	return &rateLimitedNotifier{Notifier: notifier, bucket: bucket, digestType: receiver.DigestType}
+}
+
+// 每秒检查一次限流的接收者，发送汇总消息，直到 stop 被关闭
+func (app *App) runRateLimiters(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, notifier := range app.current().notifiers {
+				if limited, ok := notifier.(*rateLimitedNotifier); ok {
+					limited.flush()
+				}
+			}
+		}
+	}
+}
+
+// 所有限流器的状态，按接收者名称排序
+func (app *App) rateLimitStats() []RateLimitStats {
+	stats := []RateLimitStats{}
+	for _, notifier := range app.current().notifiers {
+		if limited, ok := notifier.(*rateLimitedNotifier); ok {
+			stats = append(stats, limited.stats())
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Receiver < stats[j].Receiver
+	})
+	return stats
+}
+
+// 查看限流器的状态，GET /api/ratelimit
+func (app *App) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, app.rateLimitStats())
+}
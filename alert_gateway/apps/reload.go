@@ -0,0 +1,163 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// 重新读取配置文件，配置有效时替换当前的配置，无效时继续使用旧的配置并返回错误
+// 重试队列、静默文件、报警历史、升级进度、TLS 证书和监听地址在启动时创建，修改后需要重启才能生效
+func (app *App) Reload() error {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	old := app.current()
+	cfg, err := config.LoadConfig(old.config.Path)
+	if err != nil {
+		reloads.WithLabelValues("failed").Inc()
+		return err
+	}
+	if err := checkLogFile(cfg.Log); err != nil {
+		reloads.WithLabelValues("failed").Inc()
+		return err
+	}
+	state, err := app.newState(cfg)
+	if err != nil {
+		reloads.WithLabelValues("failed").Inc()
+		return err
+	}
+
+	// 去重记录在重新加载后继续使用
+	switch {
+	case cfg.Dedup.TTL <= 0:
+	case old.dedup != nil:
+		old.dedup.SetTTL(cfg.Dedup.TTL)
+		state.dedup = old.dedup
+	default:
+		state.dedup = NewDeduplicator(cfg.Dedup.TTL)
+	}
+
+	// 抖动记录在重新加载后继续使用
+	switch {
+	case cfg.Flapping.Window <= 0 || cfg.Flapping.Threshold <= 0:
+	case old.flapping != nil:
+		old.flapping.SetConfig(cfg.Flapping)
+		state.flapping = old.flapping
+	default:
+		state.flapping = NewFlapDetector(cfg.Flapping)
+	}
+
+	// 正在触发的源报警在重新加载后继续抑制
+	if state.inhibitor != nil && old.inhibitor != nil {
+		state.inhibitor.inherit(old.inhibitor)
+	}
+
+	for _, key := range restartRequired(old.config, cfg) {
+		logger.Errorf("config %s changed, restart required to take effect", key)
+	}
+
+	logger.InitLogger(cfg)
+	app.state.Store(state)
+	app.moveHeld(old, state)
+	reloads.WithLabelValues("success").Inc()
+	logger.Infof("config reloaded from %s", cfg.Path)
+	return nil
+}
+
+// 把旧的限流器中等待合并的消息移到新的同名接收者中，接收者被删除时放入重试队列
+// 旧的限流器之后收到的消息直接交给新的同名接收者发送
+func (app *App) moveHeld(old, state *appState) {
+	for name, notifier := range old.notifiers {
+		limited, ok := notifier.(*rateLimitedNotifier)
+		if !ok {
+			continue
+		}
+		next, exists := state.notifiers[name]
+		held := limited.retire(func(msg *Message) (*Result, error) {
+			if !exists {
+				return nil, fmt.Errorf("receiver %s removed on reload", name)
+			}
+			return next.Send(msg)
+		})
+		if len(held) == 0 {
+			continue
+		}
+		if next, ok := next.(*rateLimitedNotifier); ok {
+			next.mu.Lock()
+			next.held = append(held, next.held...)
+			next.mu.Unlock()
+			continue
+		}
+		// 新的接收者没有限流或已经被删除时放入重试队列
+		if app.queue == nil {
+			logger.Errorf("%d held messages to %s dropped on reload, configure [queue] to keep them", len(held), name)
+			continue
+		}
+		for _, msg := range held {
+			app.queueFailed(name, msg, fmt.Errorf("held message moved to queue on reload"))
+		}
+	}
+}
+
+// 返回修改后需要重启才能生效的配置
+func restartRequired(old, cfg *config.Config) []string {
+	var keys []string
+	if old.App.Listen != cfg.App.Listen || old.App.Port != cfg.App.Port {
+		keys = append(keys, "app.listen")
+	}
+	if old.App.TLSCertFile != cfg.App.TLSCertFile || old.App.TLSKeyFile != cfg.App.TLSKeyFile || old.App.TLSClientCAFile != cfg.App.TLSClientCAFile {
+		keys = append(keys, "app.tls")
+	}
+	if old.Queue != cfg.Queue {
+		keys = append(keys, "queue")
+	}
+	if old.History != cfg.History {
+		keys = append(keys, "history")
+	}
+	if escalationsPath(old) != escalationsPath(cfg) {
+		keys = append(keys, "escalation.path")
+	}
+	if silencesPath(old) != silencesPath(cfg) {
+		keys = append(keys, "silences.path")
+	}
+	return keys
+}
+
+// 检查日志文件能否打开，logger.InitLogger 在日志文件无法打开时会直接退出，重新加载前需要先检查
+func checkLogFile(logConfig config.Log) error {
+	if logConfig.Type != "file" && logConfig.Type != "all" {
+		return nil
+	}
+	file, err := os.OpenFile(logConfig.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	return file.Close()
+}
+
+// 重新加载配置，POST /-/reload
+func (app *App) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := app.Reload(); err != nil {
+		logger.Errorf("Failed to reload config, keep using the old one: %v", err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"status": "error", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// 检查配置能否创建通知后端、路由、模板、认证和 TLS，不会打开重试队列和静默文件
+func CheckConfig(cfg *config.Config) error {
+	app := &App{buckets: make(map[string]*tokenBucket)}
+	if _, err := app.newState(cfg); err != nil {
+		return err
+	}
+	_, err := NewTLSConfig(cfg.App)
+	return err
+}
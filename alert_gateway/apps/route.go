@@ -0,0 +1,120 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"fmt"
+	"regexp"
+)
+
+// 路由树中的节点，由 config.Route 编译而来
+type Route struct {
+	Receiver string
+	Match    map[string]string
+	MatchRE  map[string]*regexp.Regexp
+	Continue bool
+	Routes   []*Route
+}
+
+// 编译路由配置，子路由没有配置接收者时继承父路由的接收者
+// cfg 为 nil 时返回一个把所有报警发送给 defaultReceiver 的根路由
+func NewRoute(cfg *config.Route, defaultReceiver string) (*Route, error) {
+	if cfg == nil {
+		return &Route{Receiver: defaultReceiver}, nil
+	}
+
+	route := &Route{
+		Receiver: cfg.Receiver,
+		Match:    cfg.Match,
+		MatchRE:  make(map[string]*regexp.Regexp),
+		Continue: cfg.Continue,
+	}
+	if route.Receiver == "" {
+		route.Receiver = defaultReceiver
+	}
+	for name, expr := range cfg.MatchRE {
+		// 和 alertmanager 一样，正则需要匹配整个标签值
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("route match_re %s: %w", name, err)
+		}
+		route.MatchRE[name] = re
+	}
+	for _, child := range cfg.Routes {
+		childRoute, err := NewRoute(child, route.Receiver)
+		if err != nil {
+			return nil, err
+		}
+		route.Routes = append(route.Routes, childRoute)
+	}
+	return route, nil
+}
+
+// 检查标签是否满足当前节点的 match 和 match_re
+func (r *Route) matches(labels map[string]string) bool {
+	for name, value := range r.Match {
+		if labels[name] != value {
+			return false
+		}
+	}
+	for name, re := range r.MatchRE {
+		if !re.MatchString(labels[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// 返回报警标签匹配到的接收者列表
+// 深度优先匹配子路由，匹配到子路由后除非该子路由配置了 continue 否则停止匹配后面的兄弟路由，
+// 没有子路由匹配时由当前节点处理
+func (r *Route) Receivers(labels map[string]string) []string {
+	if !r.matches(labels) {
+		return nil
+	}
+
+	var receivers []string
+	for _, child := range r.Routes {
+		matched := child.Receivers(labels)
+		if len(matched) == 0 {
+			continue
+		}
+		receivers = appendUnique(receivers, matched...)
+		if !child.Continue {
+			break
+		}
+	}
+	if len(receivers) == 0 {
+		receivers = []string{r.Receiver}
+	}
+	return receivers
+}
+
+// 检查路由树中引用的接收者是否都已经配置
+func (r *Route) validate(notifiers map[string]Notifier) error {
+	if _, ok := notifiers[r.Receiver]; !ok {
+		return fmt.Errorf("route references unknown receiver %s", r.Receiver)
+	}
+	for _, child := range r.Routes {
+		if err := child.validate(notifiers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 追加不重复的元素
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,286 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 值班表，由 config.Schedule 编译而来
+type Schedule struct {
+	Name     string
+	location *time.Location
+	// 轮换周期的天数，weekly 为 7，daily 为 1
+	days int
+	// 第一个成员开始值班的日期，交接时间距离当天零点的时长
+	startYear  int
+	startMonth time.Month
+	startDay   int
+	handoff    time.Duration
+	members    []config.ScheduleMember
+	overrides  []config.ScheduleOverride
+}
+
+// 一段值班
+type Shift struct {
+	Member   string    `json:"member"`
+	Mobile   string    `json:"mobile,omitempty"`
+	UserID   string    `json:"userId,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Override bool      `json:"override"`
+}
+
+func NewSchedule(cfg config.Schedule) (*Schedule, error) {
+	if len(cfg.Members) == 0 {
+		return nil, fmt.Errorf("schedule %s: at least one member must be provided", cfg.Name)
+	}
+	s := &Schedule{Name: cfg.Name, days: 7, members: cfg.Members, overrides: cfg.Overrides}
+	if cfg.Rotation == "daily" {
+		s.days = 1
+	}
+
+	s.location = time.Local
+	if cfg.Timezone != "" {
+		location, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", cfg.Name, err)
+		}
+		s.location = location
+	}
+	start, err := time.Parse("2006-01-02", cfg.Start)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: invalid start %q", cfg.Name, cfg.Start)
+	}
+	s.startYear, s.startMonth, s.startDay = start.Date()
+	handoff := cfg.Handoff
+	if handoff == "" {
+		handoff = "09:00"
+	}
+	clock, err := time.Parse("15:04", handoff)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: invalid handoff %q", cfg.Name, cfg.Handoff)
+	}
+	s.handoff = time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+
+	for _, override := range s.overrides {
+		if s.member(override.Member) == nil {
+			return nil, fmt.Errorf("schedule %s: unknown override member %s", cfg.Name, override.Member)
+		}
+	}
+	return s, nil
+}
+
+func (s *Schedule) member(name string) *config.ScheduleMember {
+	for i := range s.members {
+		if s.members[i].Name == name {
+			return &s.members[i]
+		}
+	}
+	return nil
+}
+
+// 第 period 个轮换周期开始的时间，按日历日计算，夏令时切换时交接时间不变
+func (s *Schedule) periodStart(period int) time.Time {
+	hour := int(s.handoff / time.Hour)
+	minute := int(s.handoff % time.Hour / time.Minute)
+	return time.Date(s.startYear, s.startMonth, s.startDay+period*s.days, hour, minute, 0, 0, s.location)
+}
+
+// t 所在的轮换周期，start 之前的周期为负数
+// 先按日历日估算，再和周期开始的时间比较，夏令时切换当天零点到交接时间不是固定的时长
+func (s *Schedule) period(t time.Time) int {
+	year, month, day := t.In(s.location).Date()
+	days := int(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Sub(
+		time.Date(s.startYear, s.startMonth, s.startDay, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	period := days / s.days
+	if days%s.days != 0 && days < 0 {
+		period--
+	}
+	// 交接时间之前还是前一个周期的值班
+	for t.Before(s.periodStart(period)) {
+		period--
+	}
+	for !t.Before(s.periodStart(period + 1)) {
+		period++
+	}
+	return period
+}
+
+// t 时刻的值班人员，临时替班优先，多个替班重叠时后配置的优先
+func (s *Schedule) onCallAt(t time.Time) (*config.ScheduleMember, bool) {
+	for i := len(s.overrides) - 1; i >= 0; i-- {
+		override := s.overrides[i]
+		if !t.Before(override.Start) && t.Before(override.End) {
+			return s.member(override.Member), true
+		}
+	}
+	index := s.period(t) % len(s.members)
+	if index < 0 {
+		index += len(s.members)
+	}
+	return &s.members[index], false
+}
+
+// 当前的值班
+func (s *Schedule) OnCall(now time.Time) Shift {
+	if shifts := s.Shifts(now, now); len(shifts) > 0 {
+		return shifts[0]
+	}
+	member, override := s.onCallAt(now)
+	return Shift{Member: member.Name, Mobile: member.Mobile, UserID: member.UserID, Start: now.In(s.location), End: now.In(s.location), Override: override}
+}
+
+// from 到 until 期间的值班，第一段包含 from，相邻的同一个人的值班合并为一段
+func (s *Schedule) Shifts(from, until time.Time) []Shift {
+	first, last := s.period(from), s.period(until)+1
+	lower, upper := s.periodStart(first), s.periodStart(last)
+
+	// 轮换交接和临时替班开始、结束的时间把时间分成若干段，每段内的值班人员不变
+	var points []time.Time
+	for period := first; period <= last; period++ {
+		points = append(points, s.periodStart(period))
+	}
+	for _, override := range s.overrides {
+		for _, t := range []time.Time{override.Start, override.End} {
+			if t.After(lower) && t.Before(upper) {
+				points = append(points, t)
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Before(points[j])
+	})
+
+	var shifts []Shift
+	for i := 0; i+1 < len(points); i++ {
+		start, end := points[i], points[i+1]
+		if !end.After(start) {
+			continue
+		}
+		member, override := s.onCallAt(start)
+		if n := len(shifts); n > 0 && shifts[n-1].Member == member.Name && shifts[n-1].Override == override {
+			shifts[n-1].End = end
+			continue
+		}
+		shifts = append(shifts, Shift{
+			Member:   member.Name,
+			Mobile:   member.Mobile,
+			UserID:   member.UserID,
+			Start:    start.In(s.location),
+			End:      end.In(s.location),
+			Override: override,
+		})
+	}
+
+	// 去掉 from 之前结束和 until 之后开始的值班
+	var result []Shift
+	for _, shift := range shifts {
+		if shift.End.After(from) && !shift.Start.After(until) {
+			result = append(result, shift)
+		}
+	}
+	// 当前是临时替班时，开始时间为替班开始的时间
+	if len(result) > 0 && result[0].Override {
+		for _, override := range s.overrides {
+			if override.Member == result[0].Member && !from.Before(override.Start) && from.Before(override.End) && override.Start.Before(result[0].Start) {
+				result[0].Start = override.Start.In(s.location)
+			}
+		}
+	}
+	return result
+}
+
+// 所有的值班表，按名称查找
+type Schedules map[string]*Schedule
+
+func NewSchedules(cfgs []config.Schedule) (Schedules, error) {
+	schedules := make(Schedules)
+	for _, cfg := range cfgs {
+		if _, ok := schedules[cfg.Name]; ok {
+			return nil, fmt.Errorf("duplicate schedule name %s", cfg.Name)
+		}
+		schedule, err := NewSchedule(cfg)
+		if err != nil {
+			return nil, err
+		}
+		schedules[cfg.Name] = schedule
+	}
+	return schedules, nil
+}
+
+// 值班表当前的值班人员需要@的手机号和用户id，没有时返回 nil
+func (s Schedules) mentions(names []string, now time.Time) *DingTalkAt {
+	if len(names) == 0 {
+		return nil
+	}
+	at := &DingTalkAt{}
+	for _, name := range names {
+		schedule, ok := s[name]
+		if !ok {
+			continue
+		}
+		member, _ := schedule.onCallAt(now)
+		if member.Mobile != "" {
+			at.AtMobiles = appendUnique(at.AtMobiles, member.Mobile)
+		}
+		if member.UserID != "" {
+			at.AtUserIds = appendUnique(at.AtUserIds, member.UserID)
+		}
+	}
+	return at
+}
+
+// 值班表当前和接下来的值班
+type OnCallStatus struct {
+	Schedule string  `json:"schedule"`
+	Timezone string  `json:"timezone"`
+	Current  Shift   `json:"current"`
+	Upcoming []Shift `json:"upcoming"`
+}
+
+// 查看当前和接下来的值班，GET /api/oncall?schedule=ops&days=14
+// schedule 为空时返回所有的值班表，days 为查看接下来多少天的值班，默认 14 天
+func (app *App) handleOnCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	days := 14
+	if value := r.URL.Query().Get("days"); value != "" {
+		var err error
+		if days, err = strconv.Atoi(value); err != nil || days < 0 || days > 366 {
+			http.Error(w, fmt.Sprintf("invalid days %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+	schedules := app.current().schedules
+	name := r.URL.Query().Get("schedule")
+	if _, ok := schedules[name]; name != "" && !ok {
+		http.Error(w, fmt.Sprintf("schedule %s not found", name), http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	statuses := []OnCallStatus{}
+	for _, schedule := range schedules {
+		if name != "" && schedule.Name != name {
+			continue
+		}
+		status := OnCallStatus{Schedule: schedule.Name, Timezone: schedule.location.String(), Upcoming: []Shift{}}
+		if shifts := schedule.Shifts(now, now.AddDate(0, 0, days)); len(shifts) > 0 {
+			status.Current = shifts[0]
+			status.Upcoming = append(status.Upcoming, shifts[1:]...)
+		} else {
+			status.Current = schedule.OnCall(now)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Schedule < statuses[j].Schedule
+	})
+	writeJSON(w, http.StatusOK, statuses)
+}
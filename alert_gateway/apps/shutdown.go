@@ -0,0 +1,84 @@
+package apps
+
+import (
+	"alert_gateway/logger"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// 退出时仍未发送的消息放入重试队列时使用的错误
+var errShuttingDown = errors.New("gateway shutting down")
+
+// 优雅退出
+// 1. 先把就绪状态改为未就绪，等待 shutdown_delay 让负载均衡摘掉流量
+// 2. 停止接收新的请求，在 shutdown_timeout 内等待正在处理的请求完成
+// 3. 超时后还在处理的请求中未发送的消息放入重试队列，下次启动后重试
+// 4. 停止后台任务，把限流器中等待合并的消息放入重试队列，关闭队列、报警历史和升级进度
+func (app *App) Shutdown(server *http.Server) {
+	app.ready.Store(false)
+	appConfig := app.current().config.App
+	if delay := appConfig.ShutdownDelay; delay > 0 {
+		logger.Infof("not ready, waiting %s before shutdown", delay)
+		time.Sleep(delay)
+	}
+
+	timeout := appConfig.ShutdownTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Errorf("in-flight requests not finished within %s, persisting remaining deliveries: %v", timeout, err)
+		app.draining.Store(true)
+		server.Close()
+	}
+	// 正在发送的消息不会被中断，最长等待 http 客户端的超时时间
+	app.inflight.Wait()
+
+	app.Stop()
+	logger.Info("Server stopped")
+}
+
+// 停止后台任务，把限流器中等待合并的消息放入重试队列，关闭队列、报警历史和升级进度
+func (app *App) Stop() {
+	close(app.stop)
+	app.background.Wait()
+	app.persistHeld()
+	if app.queue != nil {
+		if err := app.queue.Close(); err != nil {
+			logger.Errorf("Failed to close queue: %v", err)
+		}
+	}
+	if app.history != nil {
+		if err := app.history.Close(); err != nil {
+			logger.Errorf("Failed to close history: %v", err)
+		}
+	}
+	if app.escalations != nil {
+		if err := app.escalations.Close(); err != nil {
+			logger.Errorf("Failed to close escalations: %v", err)
+		}
+	}
+}
+
+// 把限流器中等待合并的消息放入重试队列，没有配置重试队列时这些消息会丢失
+func (app *App) persistHeld() {
+	for _, notifier := range app.current().notifiers {
+		limited, ok := notifier.(*rateLimitedNotifier)
+		if !ok {
+			continue
+		}
+		held := limited.drain()
+		if len(held) == 0 {
+			continue
+		}
+		if app.queue == nil {
+			logger.Errorf("%d held messages to %s dropped on shutdown, configure [queue] to keep them", len(held), limited.Name())
+			continue
+		}
+		for _, msg := range held {
+			app.queueFailed(limited.Name(), msg, errShuttingDown)
+		}
+		logger.Infof("%d held messages to %s queued on shutdown", len(held), limited.Name())
+	}
+}
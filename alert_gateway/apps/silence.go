@@ -0,0 +1,252 @@
+package apps
+
+import (
+	"alert_gateway/logger"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 过期超过这个时间的静默会被删除
+const silenceRetention = 5 * 24 * time.Hour
+
+// 静默规则，在 StartsAt 和 EndsAt 之间匹配的报警不会发送
+type Silence struct {
+	ID        string    `json:"id"`
+	Matchers  Matchers  `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+	// 被这条静默抑制的报警数量
+	Suppressed uint64 `json:"suppressed"`
+}
+
+// 静默的状态 pending、active 或 expired
+func (s *Silence) State(now time.Time) string {
+	switch {
+	case now.Before(s.StartsAt):
+		return "pending"
+	case now.Before(s.EndsAt):
+		return "active"
+	default:
+		return "expired"
+	}
+}
+
+func (s *Silence) validate() error {
+	if len(s.Matchers) == 0 {
+		return errors.New("at least one matcher must be provided")
+	}
+	if s.EndsAt.IsZero() {
+		return errors.New("endsAt must be provided")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("endsAt must be after startsAt")
+	}
+	if s.CreatedBy == "" {
+		return errors.New("createdBy must be provided")
+	}
+	return nil
+}
+
+// 静默列表，修改后保存到本地文件，启动时从文件加载
+type Silences struct {
+	mu         sync.Mutex
+	path       string
+	silences   map[string]*Silence
+	suppressed uint64
+}
+
+// 从文件加载静默，文件不存在时返回空的静默列表，path 为空时不保存到文件
+func LoadSilences(path string) (*Silences, error) {
+	s := &Silences{path: path, silences: make(map[string]*Silence)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load silences %s: %w", path, err)
+	}
+	var silences []*Silence
+	if err := json.Unmarshal(data, &silences); err != nil {
+		return nil, fmt.Errorf("load silences %s: %w", path, err)
+	}
+	for _, silence := range silences {
+		s.silences[silence.ID] = silence
+	}
+	return s, nil
+}
+
+// 保存到文件，先写临时文件再重命名，避免写入过程中退出导致文件损坏，调用方需要持有锁
+func (s *Silences) save() error {
+	if s.path == "" {
+		return nil
+	}
+	now := time.Now()
+	silences := make([]*Silence, 0, len(s.silences))
+	for id, silence := range s.silences {
+		if now.Sub(silence.EndsAt) > silenceRetention {
+			delete(s.silences, id)
+			continue
+		}
+		silences = append(silences, silence)
+	}
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].StartsAt.Before(silences[j].StartsAt)
+	})
+
+	data, err := json.MarshalIndent(silences, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// 创建静默，startsAt 为空时从现在开始
+func (s *Silences) Create(silence *Silence) error {
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = time.Now()
+	}
+	if err := silence.validate(); err != nil {
+		return err
+	}
+	silence.ID = newSilenceID()
+	silence.Suppressed = 0
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silences[silence.ID] = silence
+	if err := s.save(); err != nil {
+		delete(s.silences, silence.ID)
+		return fmt.Errorf("save silences: %w", err)
+	}
+	return nil
+}
+
+// 让静默立即过期
+func (s *Silences) Expire(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	silence, ok := s.silences[id]
+	if !ok {
+		return fmt.Errorf("silence %s not found", id)
+	}
+	now := time.Now()
+	if silence.State(now) == "expired" {
+		return fmt.Errorf("silence %s already expired", id)
+	}
+	startsAt, endsAt := silence.StartsAt, silence.EndsAt
+	if silence.StartsAt.After(now) {
+		silence.StartsAt = now
+	}
+	silence.EndsAt = now
+	if err := s.save(); err != nil {
+		silence.StartsAt, silence.EndsAt = startsAt, endsAt
+		return fmt.Errorf("save silences: %w", err)
+	}
+	return nil
+}
+
+// 列出静默，state 不为空时只返回对应状态的静默
+func (s *Silences) List(state string) []Silence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	silences := []Silence{}
+	for _, silence := range s.silences {
+		if state == "" || silence.State(now) == state {
+			silences = append(silences, *silence)
+		}
+	}
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].StartsAt.Before(silences[j].StartsAt)
+	})
+	return silences
+}
+
+// 返回匹配报警的生效中的静默 id，没有匹配时返回空字符串
+func (s *Silences) Mutes(labels map[string]string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, silence := range s.silences {
+		if silence.State(now) == "active" && silence.Matchers.Matches(labels) {
+			silence.Suppressed++
+			s.suppressed++
+			return silence.ID
+		}
+	}
+	return ""
+}
+
+// 被静默抑制的报警总数
+func (s *Silences) Suppressed() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.suppressed
+}
+
+// 生成 uuid 格式的随机 id
+func newSilenceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// 查看和创建静默
+// GET /api/silences?state=active 列出静默
+// POST /api/silences 创建静默
+func (app *App) handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, app.silences.List(r.URL.Query().Get("state")))
+	case http.MethodPost:
+		var silence Silence
+		if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+			http.Error(w, fmt.Sprintf("解析json数据失败: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := app.silences.Create(&silence); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Infof("silence %s created by %s: %v until %s", silence.ID, silence.CreatedBy, silence.Matchers, silence.EndsAt.Format(time.RFC3339))
+		writeJSON(w, http.StatusOK, map[string]string{"silenceID": silence.ID})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// 让静默过期，DELETE /api/silences/<id>
+func (app *App) handleSilence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/silences/")
+	if err := app.silences.Expire(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.Infof("silence %s expired", id)
+	writeJSON(w, http.StatusOK, map[string]string{"silenceID": id})
+}
@@ -0,0 +1,63 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"encoding/json"
+	"fmt"
+)
+
+// Slack incoming webhook消息结构
+type SlackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []SlackBlock `json:"blocks,omitempty"`
+}
+
+// Slack消息块
+type SlackBlock struct {
+	Type string     `json:"type"`
+	Text *SlackText `json:"text,omitempty"`
+}
+
+// Slack消息块中的文本
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Slack incoming webhook通知后端，Slack的incoming webhook地址本身就是凭证，没有签名机制
+type Slack struct {
+	receiverBase
+}
+
+func newSlack(receiver config.Receiver) (*Slack, error) {
+	if receiver.WebhookURL == "" {
+		return nil, fmt.Errorf("receiver %s: webhook_url must be provided", receiver.Name)
+	}
+	return &Slack{receiverBase{receiver: receiver}}, nil
+}
+
+func (s *Slack) Send(msg *Message) (*Result, error) {
+	sendData := SlackMessage{Text: msg.Content}
+	if msg.Type != "text" {
+		// text 字段作为通知栏中的摘要，正文放在 mrkdwn 块里
+		sendData.Text = msg.Title
+		sendData.Blocks = []SlackBlock{
+			{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: msg.Content}},
+		}
+	}
+
+	sendDataBytes, err := json.Marshal(sendData)
+	if err != nil {
+		logger.Errorf("Failed to marshal JSON: %v", err)
+		return nil, err
+	}
+	logger.Debug(string(sendDataBytes))
+
+	statusCode, respBody, err := postJSON(s.receiver.WebhookURL, sendDataBytes, nil)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("发送Slack响应 %s", respBody)
+	return &Result{Receiver: s.Name(), StatusCode: statusCode, RespMsg: respBody}, nil
+}
@@ -0,0 +1,293 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// 内置的默认模板，和之前 createMarkDown/createText 生成的内容一致，标签按名称排序
+const defaultTemplates = `
+{{ define "default.markdown" }}{{ range .Alerts }}# <font color={{ if eq .Status "resolved" }}#00FF00{{ else }}#FF0000{{ end }}>{{ $.Title }}</font>
+
+- summary: {{ index .Annotations "summary" }}
+{{ range sortLabels .Labels }}- {{ .Name }}: {{ .Value }}
+{{ end }}- StartsAt: {{ formatTime .StartsAt }}
+{{ with .ValueString }}- Value: {{ . }}
+{{ end }}{{ with or .PanelURL .DashboardURL }}- Panel: [{{ . }}]({{ . }})
+{{ end }}{{ end }}{{ end }}
+
+{{ define "default.text" }}{{ range .Alerts }}content: {{ $.Title }}
+summary: {{ index .Annotations "summary" }}
+{{ range sortLabels .Labels }}{{ .Name }}: {{ .Value }}
+{{ end }}StartsAt: {{ formatTime .StartsAt }}
+{{ with .ValueString }}Value: {{ . }}
+{{ end }}{{ with or .PanelURL .DashboardURL }}Panel: {{ . }}
+{{ end }}{{ end }}{{ end }}
+
+{{ define "default.group.markdown" }}# <font color={{ if eq .Status "resolved" }}#00FF00{{ else }}#FF0000{{ end }}>{{ .Title }}</font>
+{{ with .Firing }}
+## <font color=#FF0000>故障 {{ len . }}</font>
+{{ range . }}
+- {{ .Labels.alertname }} {{ .Labels.instance }} {{ index .Annotations "summary" }} ({{ formatTime .StartsAt }})
+{{- end }}
+{{ end }}{{ with .Resolved }}
+## <font color=#00FF00>恢复 {{ len . }}</font>
+{{ range . }}
+- {{ .Labels.alertname }} {{ .Labels.instance }} {{ index .Annotations "summary" }} ({{ formatTime .StartsAt }})
+{{- end }}
+{{ end }}{{ if .Truncated }}
++{{ .Truncated }} more
+{{ end }}{{ end }}
+
+{{ define "default.group.text" }}content: {{ .Title }}
+{{ with .Firing }}
+故障 {{ len . }}:
+{{ range . }}{{ .Labels.alertname }} {{ .Labels.instance }} {{ index .Annotations "summary" }} ({{ formatTime .StartsAt }})
+{{ end }}{{ end }}{{ with .Resolved }}
+恢复 {{ len . }}:
+{{ range . }}{{ .Labels.alertname }} {{ .Labels.instance }} {{ index .Annotations "summary" }} ({{ formatTime .StartsAt }})
+{{ end }}{{ end }}{{ if .Truncated }}
++{{ .Truncated }} more
+{{ end }}{{ end }}
+`
+
+// 模板渲染时传入的数据，包含 alertmanager 发送的完整数据
+// Alerts 为本条消息包含的报警，Status 为本条消息的状态，Truncated 为超过消息长度限制被省略的报警数量
+type TemplateData struct {
+	AlertData
+	Title     string
+	Truncated int
+}
+
+// 本条消息中的故障报警
+func (d *TemplateData) Firing() []Alert {
+	return d.filter("firing")
+}
+
+// 本条消息中的恢复报警
+func (d *TemplateData) Resolved() []Alert {
+	return d.filter("resolved")
+}
+
+// 本条消息中所有报警某个标签的值，去掉重复和空值，按出现的顺序排列，如 {{ .LabelValues "instance" | join ", " }}
+func (d *TemplateData) LabelValues(name string) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, alert := range d.Alerts {
+		if value := alert.Labels[name]; value != "" && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (d *TemplateData) filter(status string) []Alert {
+	var alerts []Alert
+	for _, alert := range d.Alerts {
+		if (alert.Status == "resolved") == (status == "resolved") {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// 模板中 sortLabels 返回的标签
+type Label struct {
+	Name  string
+	Value string
+}
+
+// 加载后的消息模板
+type Templates struct {
+	tmpl          *template.Template
+	location      *time.Location
+	markdown      string
+	text          string
+	groupMarkdown string
+	groupText     string
+}
+
+// 加载内置模板和配置文件中指定的模板文件，模板解析失败时返回错误
+func LoadTemplates(cfg config.Templates) (*Templates, error) {
+	location := time.Local
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("load template timezone %s: %w", cfg.Timezone, err)
+		}
+		location = loc
+	}
+
+	t := &Templates{
+		location:      location,
+		markdown:      "default.markdown",
+		text:          "default.text",
+		groupMarkdown: "default.group.markdown",
+		groupText:     "default.group.text",
+	}
+	tmpl, err := template.New("default").Funcs(t.funcMap()).Parse(defaultTemplates)
+	if err != nil {
+		return nil, fmt.Errorf("parse default templates: %w", err)
+	}
+
+	for _, pattern := range cfg.Files {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("template files %s: %w", pattern, err)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("template files %s: no files found", pattern)
+		}
+		if tmpl, err = tmpl.ParseFiles(files...); err != nil {
+			return nil, fmt.Errorf("parse template files %s: %w", pattern, err)
+		}
+	}
+	t.tmpl = tmpl
+
+	if cfg.Markdown != "" {
+		t.markdown = cfg.Markdown
+	}
+	if cfg.Text != "" {
+		t.text = cfg.Text
+	}
+	if cfg.GroupMarkdown != "" {
+		t.groupMarkdown = cfg.GroupMarkdown
+	}
+	if cfg.GroupText != "" {
+		t.groupText = cfg.GroupText
+	}
+	for _, name := range []string{t.markdown, t.text, t.groupMarkdown, t.groupText} {
+		if t.tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("template %s not defined", name)
+		}
+	}
+	return t, nil
+}
+
+// 检查模板是否已经定义
+func (t *Templates) has(name string) bool {
+	return t.tmpl.Lookup(name) != nil
+}
+
+// 按消息类型和是否分组选择默认模板
+func (t *Templates) defaultName(messageType string, grouped bool) string {
+	switch {
+	case grouped && messageType == "text":
+		return t.groupText
+	case grouped:
+		return t.groupMarkdown
+	case messageType == "text":
+		return t.text
+	default:
+		return t.markdown
+	}
+}
+
+// 使用指定名称的模板渲染
+func (t *Templates) Render(name string, data *TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", fmt.Errorf("render template %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// 模板中可以使用的辅助函数
+func (t *Templates) funcMap() template.FuncMap {
+	return template.FuncMap{
+		"sortLabels":       sortLabels,
+		"formatTime":       t.formatTime,
+		"humanizeDuration": humanizeDuration,
+		"duration":         duration,
+		"toUpper":          strings.ToUpper,
+		"join":             join,
+	}
+}
+
+// 把标签按名称排序
+func sortLabels(labels map[string]string) []Label {
+	sorted := make([]Label, 0, len(labels))
+	for name, value := range labels {
+		sorted = append(sorted, Label{Name: name, Value: value})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// 把 RFC3339 格式的时间转换为配置的时区，layout 不传时使用 2006-01-02 15:04:05
+// 时间解析失败时原样返回，避免一个格式错误的时间导致整条消息发送失败
+func (t *Templates) formatTime(timeStr string, layout ...string) string {
+	parsed, err := time.Parse(time.RFC3339, timeStr)
+	if err != nil {
+		return timeStr
+	}
+	format := "2006-01-02 15:04:05"
+	if len(layout) > 0 {
+		format = layout[0]
+	}
+	return parsed.In(t.location).Format(format)
+}
+
+// 计算两个 RFC3339 时间之间的时长，end 为空或者为零值时计算到现在
+func duration(start, end string) (time.Duration, error) {
+	startTime, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return 0, err
+	}
+	endTime := time.Now()
+	if end != "" {
+		parsed, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return 0, err
+		}
+		// alertmanager 对仍在触发的报警发送 0001-01-01T00:00:00Z
+		if parsed.Year() > 1 {
+			endTime = parsed
+		}
+	}
+	return endTime.Sub(startTime), nil
+}
+
+// 把时长转换为易读的格式，如 1d 2h 3m 4s
+func humanizeDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.String()
+	}
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+	seconds := d / time.Second
+
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	if minutes > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	}
+	if seconds > 0 {
+		parts = append(parts, fmt.Sprintf("%ds", seconds))
+	}
+	return strings.Join(parts, " ")
+}
+
+// 参数顺序和 strings.Join 相反，方便在管道中使用，如 {{ .LabelValues "instance" | join ", " }}
+func join(sep string, items []string) string {
+	return strings.Join(items, sep)
+}
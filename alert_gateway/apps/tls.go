@@ -0,0 +1,135 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 从文件加载证书，文件修改后在下一次握手时重新加载，更新证书不需要重启
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu      sync.Mutex
+	modTime time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+// 读取 [app] 中的 tls_cert_file、tls_key_file 和 tls_client_ca_file，没有配置证书时返回 nil
+// 配置了 tls_client_ca_file 时要求客户端提供由该 CA 签发的证书（mTLS）
+func NewTLSConfig(appConfig config.App) (*tls.Config, error) {
+	certFile := appConfig.TLSCertFile
+	keyFile := appConfig.TLSKeyFile
+	caFile := appConfig.TLSClientCAFile
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return nil, fmt.Errorf("tls_client_ca_file requires tls_cert_file and tls_key_file")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("tls_cert_file and tls_key_file must be provided together")
+	}
+
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	// 启动时加载失败直接报错
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := r.current()
+		return cert, nil
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := r.current()
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.GetCertificate = nil
+		cfg.Certificates = []tls.Certificate{*cert}
+		if pool != nil {
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return cfg, nil
+	}
+	return base, nil
+}
+
+// 证书文件的最新修改时间
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// 重新加载证书和客户端 CA
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return fmt.Errorf("load tls certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load tls certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("load tls client ca: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("load tls client ca: no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.pool = pool
+	r.modTime = modTime
+	return nil
+}
+
+// 返回当前的证书，文件有修改时先重新加载，加载失败时继续使用旧的证书
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	loaded := r.modTime
+	r.mu.Unlock()
+
+	if modTime, err := r.latestModTime(); err == nil && modTime.After(loaded) {
+		if err := r.reload(); err != nil {
+			logger.Errorf("Failed to reload tls certificate, keep using the old one: %v", err)
+			r.mu.Lock()
+			r.modTime = modTime
+			r.mu.Unlock()
+		} else {
+			logger.Infof("tls certificate reloaded from %s", r.certFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, r.pool
+}
@@ -2,9 +2,9 @@ package apps
 
 import (
 	"alert_gateway/logger"
-	"bytes"
-	"fmt"
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
 	"time"
 )
 
@@ -29,53 +29,15 @@ func getTime() string {
 	return time.Now().Format("2006-01-02 15:04:05")
 }
 
-func timeFormat(timeStr string) (string, error) {
-	// 待转换的时间字符串
-	//timeStr := "2024-07-30T20:19:09.673Z"
-
-	// 解析时间字符串，指定输入格式
-	t, err := time.Parse(time.RFC3339, timeStr)
-	if err != nil {
-		fmt.Println("解析时间错误:", err)
-		return "", err
-	}
-
-	// 格式化为目标格式
-	newTimeStr := t.Format("2006-01-02 15:04:05")
-	return newTimeStr, nil
-}
-
-func createMarkDown(title, color string, alert Alert) string {
-	var markdown bytes.Buffer
-
-	// Construct the Markdown string
-	markdown.WriteString(fmt.Sprintf("# <font color=%s>%s</font>\n\n", color, title))
-	//markdown.WriteString("## Items\n\n")
-	markdown.WriteString(fmt.Sprintf("- summary: %s \n", alert.Annotations["summary"]))
-	for key, item := range alert.Labels {
-		markdown.WriteString(fmt.Sprintf("- %s: %s\n", key, item))
-	}
-	startsAt, err := timeFormat(alert.StartsAt)
+// 以json格式返回响应
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	body, err := json.Marshal(v)
 	if err != nil {
-		logger.Error("create Markdown err")
+		http.Error(w, "Failed to marshal response JSON", http.StatusInternalServerError)
+		logger.Errorf("Failed to marshal response JSON: %v", err)
+		return
 	}
-	markdown.WriteString(fmt.Sprintf("- StartsAt: %s \n", startsAt))
-
-	return markdown.String()
-}
-
-func createText(title string, alert Alert) string {
-	var textContent bytes.Buffer
-	textContent.WriteString(fmt.Sprintf("content: %s\n", title))
-	textContent.WriteString(fmt.Sprintf("summary: %s \n", alert.Annotations["summary"]))
-	for key, item := range alert.Labels {
-		textContent.WriteString(fmt.Sprintf("%s: %s\n", key, item))
-	}
-	startsAt, err := timeFormat(alert.StartsAt)
-	if err != nil {
-		logger.Error("create text err")
-	}
-	textContent.WriteString(fmt.Sprintf("StartsAt: %s \n", startsAt))
-
-	return textContent.String()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
 }
@@ -3,21 +3,166 @@ package apps
 import (
 	"alert_gateway/config"
 	"alert_gateway/logger"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 )
 
 // 定义应用结构体，包含配置信息，用来
 type App struct {
-	config *config.Config
+	// 可以热加载的配置和根据配置创建的对象，重新加载时整体替换
+	state atomic.Pointer[appState]
+	// 同一个机器人的令牌桶，重新加载后继续使用，避免重新加载后超过限流
+	buckets  map[string]*tokenBucket
+	reloadMu sync.Mutex
+
+	queue    *RetryQueue
+	silences *Silences
+	history  *AlertHistory
+	tls      *tls.Config
+	// 没有配置升级策略时为 nil
+	escalations *Escalations
+	// alertmanager v2 API 推送的报警最后发送的状态
+	v2Status *StatusTracker
+	// 关闭后停止后台任务
+	stop       chan struct{}
+	background sync.WaitGroup
+	// 正在处理的报警请求
+	inflight sync.WaitGroup
+	// 是否可以接收请求，退出时先改为 false
+	ready atomic.Bool
+	// 退出超时后为 true，未发送的消息直接放入重试队列
+	draining atomic.Bool
+}
+
+// 根据配置创建的对象，创建后不再修改
+type appState struct {
+	config    *config.Config
+	notifiers map[string]Notifier
+	route     *Route
+	templates *Templates
+	auth      *Authenticator
+	dedup     *Deduplicator
+	// 没有配置抑制规则时为 nil
+	inhibitor *Inhibitor
+	// 没有开启抖动检测时为 nil
+	flapping  *FlapDetector
+	schedules Schedules
 }
 
 // 工厂函数，接收一个配置信息作为参数，返回一个包含配置信息的App对象
 // 在包外部可以调用后获得一个包含配置信息的对象后就在外部启动web应用
-func NewApp(cfg *config.Config) *App {
-	return &App{config: cfg}
+func NewApp(cfg *config.Config) (*App, error) {
+	app := &App{buckets: make(map[string]*tokenBucket), stop: make(chan struct{}), v2Status: NewStatusTracker()}
+	state, err := app.newState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Dedup.TTL > 0 {
+		state.dedup = NewDeduplicator(cfg.Dedup.TTL)
+	}
+	if cfg.Flapping.Window > 0 && cfg.Flapping.Threshold > 0 {
+		state.flapping = NewFlapDetector(cfg.Flapping)
+	}
+	app.state.Store(state)
+
+	if cfg.Queue.Path != "" {
+		if app.queue, err = OpenRetryQueue(cfg.Queue, app.sendEntry); err != nil {
+			return nil, err
+		}
+	}
+	if app.silences, err = LoadSilences(silencesPath(cfg)); err != nil {
+		return nil, err
+	}
+	if cfg.History.Path != "" {
+		if app.history, err = OpenHistory(cfg.History); err != nil {
+			return nil, err
+		}
+	}
+	if path := escalationsPath(cfg); path != "" {
+		if app.escalations, err = OpenEscalations(path); err != nil {
+			return nil, err
+		}
+	}
+	if app.tls, err = NewTLSConfig(cfg.App); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// 根据配置创建值班表、通知后端、路由、模板、认证和抑制规则，配置有错误时返回错误，去重由调用方设置
+func (app *App) newState(cfg *config.Config) (*appState, error) {
+	schedules, err := NewSchedules(cfg.Schedules)
+	if err != nil {
+		return nil, err
+	}
+	notifiers, err := buildNotifiers(cfg, app.buckets, schedules)
+	if err != nil {
+		return nil, err
+	}
+	route, err := NewRoute(cfg.Route, defaultReceiver(cfg))
+	if err != nil {
+		return nil, err
+	}
+	if err := route.validate(notifiers); err != nil {
+		return nil, err
+	}
+	templates, err := LoadTemplates(cfg.Templates)
+	if err != nil {
+		return nil, err
+	}
+	for _, notifier := range notifiers {
+		if name := notifier.Template(); name != "" && !templates.has(name) {
+			return nil, fmt.Errorf("receiver %s: template %s not defined", notifier.Name(), name)
+		}
+	}
+	auth, err := NewAuthenticator(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	var inhibitor *Inhibitor
+	if len(cfg.InhibitRules) > 0 {
+		if inhibitor, err = NewInhibitor(cfg.InhibitRules, cfg.Inhibit.SourceTTL); err != nil {
+			return nil, err
+		}
+	}
+	for _, notifier := range notifiers {
+		if limited, ok := notifier.(*rateLimitedNotifier); ok {
+			limited.onFailure = app.queueFailed
+		}
+	}
+	return &appState{config: cfg, notifiers: notifiers, route: route, templates: templates, auth: auth, inhibitor: inhibitor, schedules: schedules}, nil
+}
+
+// 当前生效的配置
+func (app *App) current() *appState {
+	return app.state.Load()
+}
+
+// 静默文件的路径，默认为 silences.json
+func silencesPath(cfg *config.Config) string {
+	if cfg.Silences.Path == "" {
+		return "silences.json"
+	}
+	return cfg.Silences.Path
+}
+
+// 获取默认的接收者，优先使用 [app] 中 receiver 指定的接收者，否则使用配置中的第一个接收者
+func defaultReceiver(cfg *config.Config) string {
+	if cfg.App.Receiver != "" {
+		return cfg.App.Receiver
+	}
+	if len(cfg.Receivers) > 0 {
+		return cfg.Receivers[0].Name
+	}
+	return "default"
 }
 
 // 启动应用
@@ -26,17 +171,105 @@ func (app *App) Run() {
 	logger.Error("error log")
 	logger.Debug("debug log")
 
-	appConfig := app.config.App
-	addr := fmt.Sprintf("%s:%v", appConfig["listen"], appConfig["port"])
+	appConfig := app.current().config.App
+	addr := fmt.Sprintf("%s:%d", appConfig.Listen, appConfig.Port)
 	logger.Infof("Server running on %s", addr)
 
 	server := http.Server{
-		Addr: addr,
+		Addr:      addr,
+		Handler:   app.Handler(),
+		TLSConfig: app.tls,
 	}
-	http.HandleFunc("/", app.index)
-	if err := server.ListenAndServe(); err != nil {
-		logger.Errorf("Server error: %v", err)
+	app.Start()
+
+	errs := make(chan error, 1)
+	go func() {
+		if app.tls != nil {
+			// 配置了证书时只监听 https，明文的 http 请求会被拒绝
+			logger.Info("TLS enabled")
+			errs <- server.ListenAndServeTLS("", "")
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	// 收到 SIGHUP 时重新加载配置，收到 SIGINT 或 SIGTERM 时优雅退出
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	for running := true; running; {
+		select {
+		case err := <-errs:
+			logger.Errorf("Server error: %v", err)
+			running = false
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if err := app.Reload(); err != nil {
+					logger.Errorf("Failed to reload config, keep using the old one: %v", err)
+				}
+				continue
+			}
+			logger.Infof("Received %s, shutting down", sig)
+			running = false
+		}
+	}
+	app.Shutdown(&server)
+}
+
+// 网关的所有接口
+func (app *App) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", app.index)
+	// 指定格式接收报警，/webhook/grafana、/webhook/plain 等，/api/v2/alerts 和 alertmanager 的接口兼容，prometheus 可以直接推送
+	mux.HandleFunc("/webhook/", app.handleWebhook)
+	mux.HandleFunc("/api/v2/alerts", app.handleWebhook)
+	mux.HandleFunc("/healthz", app.handleHealthz)
+	mux.HandleFunc("/readyz", app.handleReadyz)
+	mux.Handle("/metrics", app.handleMetrics())
+	mux.HandleFunc("/-/reload", app.authenticate(app.handleReload))
+	// 值班表中有值班人员的手机号，和接收报警使用相同的认证
+	mux.HandleFunc("/api/oncall", app.authenticate(app.handleOnCall))
+	mux.HandleFunc("/api/ratelimit", app.authenticate(app.handleRateLimit))
+	// 静默和重试队列的接口可以修改网关的状态，和接收报警使用相同的认证
+	mux.HandleFunc("/api/silences", app.authenticate(app.handleSilences))
+	mux.HandleFunc("/api/silences/", app.authenticate(app.handleSilence))
+	if app.queue != nil {
+		mux.HandleFunc("/api/queue", app.authenticate(app.handleQueue))
+		mux.HandleFunc("/api/queue/replay", app.authenticate(app.handleQueueReplay))
+	}
+	if app.history != nil {
+		mux.HandleFunc("/api/alerts", app.authenticate(app.handleAlerts))
+	}
+	if app.escalations != nil {
+		mux.HandleFunc("/api/escalations", app.authenticate(app.handleEscalations))
+		mux.HandleFunc("/api/escalations/", app.authenticate(app.handleEscalation))
 	}
+	return mux
+}
+
+// 启动限流、抖动检测、重试队列、报警历史和升级的后台任务，启动后就绪检查返回就绪
+func (app *App) Start() {
+	app.goBackground(app.runRateLimiters)
+	app.goBackground(app.runFlapDetector)
+	if app.queue != nil {
+		app.goBackground(app.queue.Run)
+	}
+	if app.history != nil {
+		app.goBackground(app.history.Run)
+	}
+	if app.escalations != nil {
+		app.goBackground(app.runEscalations)
+	}
+	app.ready.Store(true)
+}
+
+// 启动后台任务，退出时等待任务结束
+func (app *App) goBackground(run func(stop <-chan struct{})) {
+	app.background.Add(1)
+	go func() {
+		defer app.background.Done()
+		run(app.stop)
+	}()
 }
 
 // type IndexData struct {
@@ -53,6 +286,13 @@ type Alert struct {
 	EndsAt       string            `json:"endsAt"`
 	GeneratorURL string            `json:"generatorURL"`
 	Fingerprint  string            `json:"fingerprint"`
+	// grafana 统一告警的字段，其他来源为空
+	Values       map[string]float64 `json:"values,omitempty"`
+	ValueString  string             `json:"valueString,omitempty"`
+	DashboardURL string             `json:"dashboardURL,omitempty"`
+	PanelURL     string             `json:"panelURL,omitempty"`
+	SilenceURL   string             `json:"silenceURL,omitempty"`
+	ImageURL     string             `json:"imageURL,omitempty"`
 }
 
 // 定义alertmanager发送的报警结构体
@@ -67,30 +307,20 @@ type AlertData struct {
 	Version           string            `json:"version"`
 	GroupKey          string            `json:"groupKey"`
 	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	// grafana 统一告警的字段，其他来源为空
+	OrgID   int64  `json:"orgId,omitempty"`
+	Title   string `json:"title,omitempty"`
+	State   string `json:"state,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
-// 钉钉Markdown消息结构
-type MarkdownMessage struct {
-	MsgType  string   `json:"msgtype"`
-	Markdown Markdown `json:"markdown"`
-}
-
-// 钉钉文本消息结构
-type TextMessage struct {
-	MsgType string `json:"msgtype"`
-	Text    Text   `json:"text"`
-}
-
-// Markdown内容结构
-type Markdown struct {
-	Title string `json:"title"`
-	Text  string `json:"text"`
-	Theme string `json:"theme"`
-}
-
-// 文本内容结构
-type Text struct {
-	Content string `json:"content"`
+// 指定解码器接收报警，只支持 POST
+func (app *App) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	app.authenticate(app.handlePost)(w, r)
 }
 
 func (app *App) index(w http.ResponseWriter, r *http.Request) {
@@ -100,7 +330,7 @@ func (app *App) index(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		app.handleGet(w, r)
 	case http.MethodPost:
-		app.handlePost(w, r)
+		app.authenticate(app.handlePost)(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -127,6 +357,7 @@ func (app *App) handleGet(w http.ResponseWriter, r *http.Request) {
 	helpMessage, err := ReadFileContent("msg.txt")
 	if err != nil {
 		logger.Error(err)
+		http.Error(w, "help message not found", http.StatusNotFound)
 		return
 	}
 	// logger.Debugf("测试数据输出 %s\n", *helpMessage)
@@ -143,6 +374,8 @@ func (app *App) handleGet(w http.ResponseWriter, r *http.Request) {
 
 // 处理post请求
 func (app *App) handlePost(w http.ResponseWriter, r *http.Request) {
+	app.inflight.Add(1)
+	defer app.inflight.Done()
 	logger.Debug("Handling POST request")
 	w.Header().Set("Content-Type", "application/json")
 
@@ -157,53 +390,35 @@ func (app *App) handlePost(w http.ResponseWriter, r *http.Request) {
 	// logger.Debugf("Received body: %s \n", string(body))
 	// logger.Debugf("----- %s ------", getTime())
 
-	var alertData AlertData
-	err = json.Unmarshal(body, &alertData)
-	if err != nil {
-		http.Error(w, "解析json数据失败", http.StatusBadRequest)
-		logger.Errorf("Failed to parse JSON data: %v", err)
+	// 按路径或请求体的格式选择解码器，转换为统一的报警格式
+	name := decoderName(r.URL.Path)
+	if name != "" && decoderByName(name) == nil {
+		http.Error(w, fmt.Sprintf("unknown decoder %q", name), http.StatusNotFound)
+		return
 	}
-
-	// 定义一个map类型的切片，存放发送钉钉消息的结果数据
-	var responses []map[string]interface{}
-
-	// 读取配置文件设置发现钉钉的消息类型
-	messageType := "markdown" // 默认消息类型
-	if cfgMessageType, ok := app.config.App["messageType"].(string); ok {
-		messageType = cfgMessageType
+	alertData, decoder, err := DecodeAlerts(name, body)
+	if err != nil {
+		http.Error(w, "解析json数据失败: "+err.Error(), http.StatusBadRequest)
+		logger.Errorf("Failed to parse %s alerts: %v", decoder, err)
+		return
 	}
+	logger.Debugf("Decoded %d alerts from %s", len(alertData.Alerts), decoder)
 
-	// 循环发送报警信息到钉钉
+	webhooksReceived.Inc()
 	for _, alert := range alertData.Alerts {
-		var message string
-		if alert.Status == "resolved" {
-			if messageType == "text" {
-				message = createText("恢复", alert)
-			} else {
-				message = createMarkDown("恢复", "#00FF00", alert)
-			}
-		} else {
-			if messageType == "text" {
-				message = createText("故障", alert)
-			} else {
-				message = createMarkDown("故障", "#FF0000", alert)
-			}
-		}
-
-		respMsg, err := sendMsg(message, messageType, *app.config)
-		response := map[string]interface{}{
-			"alert":   alert.Labels["instance"],
-			"respMsg": respMsg,
-			"error":   err,
-		}
+		alertsReceived.WithLabelValues(alert.Status, alert.Labels["alertname"]).Inc()
+	}
 
-		responses = append(responses, response)
+	// prometheus 会重复推送状态没有变化的报警
+	v2 := decoder == "alertmanager-v2"
+	if v2 {
+		app.dropRepeated(&alertData)
+	}
 
-		if err != nil {
-			logger.Errorf("Failed to send message: %v", err)
-		} else {
-			logger.Debugf("Response message: %s", respMsg)
-		}
+	// 按路由发送报警信息，返回每条报警的发送结果
+	responses, failed := app.dispatch(alertData)
+	if v2 {
+		app.forgetUndelivered(alertData.Alerts, responses)
 	}
 
 	responseBody, err := json.Marshal(responses)
@@ -213,6 +428,11 @@ func (app *App) handlePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 有消息发送失败时返回 502，alertmanager 会重新推送
+	if failed > 0 {
+		logger.Errorf("%d deliveries failed", failed)
+		w.WriteHeader(http.StatusBadGateway)
+	}
 	// Echo the response back to the client
 	w.Write(responseBody)
 }
@@ -0,0 +1,71 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// 通用webhook消息结构
+type WebhookMessage struct {
+	Receiver string  `json:"receiver"`
+	Status   string  `json:"status"`
+	Title    string  `json:"title"`
+	Message  string  `json:"message"`
+	Alerts   []Alert `json:"alerts"`
+}
+
+// 通用webhook通知后端，把渲染后的消息和原始报警以json格式POST到配置的地址
+// 配置了secret时，使用 HMAC-SHA256 对请求体签名，十六进制结果放在 X-Alert-Gateway-Signature 请求头中
+type Webhook struct {
+	receiverBase
+}
+
+func newWebhook(receiver config.Receiver) (*Webhook, error) {
+	if receiver.WebhookURL == "" {
+		return nil, fmt.Errorf("receiver %s: webhook_url must be provided", receiver.Name)
+	}
+	return &Webhook{receiverBase{receiver: receiver}}, nil
+}
+
+func (wh *Webhook) Send(msg *Message) (*Result, error) {
+	sendData := WebhookMessage{
+		Receiver: wh.Name(),
+		Status:   msg.Status,
+		Title:    msg.Title,
+		Message:  msg.Content,
+		Alerts:   msg.Alerts,
+	}
+	sendDataBytes, err := json.Marshal(sendData)
+	if err != nil {
+		logger.Errorf("Failed to marshal JSON: %v", err)
+		return nil, err
+	}
+	logger.Debug(string(sendDataBytes))
+
+	headers := make(map[string]string)
+	for key, value := range wh.receiver.Headers {
+		headers[key] = string(value)
+	}
+	if wh.receiver.Secret != "" {
+		headers["X-Alert-Gateway-Signature"] = makeWebhookSign(sendDataBytes, string(wh.receiver.Secret))
+	}
+
+	statusCode, respBody, err := postJSON(wh.receiver.WebhookURL, sendDataBytes, headers)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("发送webhook响应 %s", respBody)
+	return &Result{Receiver: wh.Name(), StatusCode: statusCode, RespMsg: respBody}, nil
+}
+
+// 使用secret对请求体计算 HMAC-SHA256，返回十六进制字符串
+func makeWebhookSign(body []byte, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,74 @@
+package apps
+
+import (
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"encoding/json"
+	"fmt"
+)
+
+// 企业微信Markdown消息结构
+type WeComMarkdownMessage struct {
+	MsgType  string        `json:"msgtype"`
+	Markdown WeComMarkdown `json:"markdown"`
+}
+
+// 企业微信文本消息结构
+type WeComTextMessage struct {
+	MsgType string    `json:"msgtype"`
+	Text    WeComText `json:"text"`
+}
+
+// 企业微信Markdown内容结构
+type WeComMarkdown struct {
+	Content string `json:"content"`
+}
+
+// 企业微信文本内容结构
+type WeComText struct {
+	Content string `json:"content"`
+}
+
+// 企业微信群机器人通知后端，企业微信机器人没有签名机制，依靠webhook地址中的key鉴权
+type WeCom struct {
+	receiverBase
+}
+
+func newWeCom(receiver config.Receiver) (*WeCom, error) {
+	if receiver.WebhookURL == "" {
+		receiver.WebhookURL = "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key="
+	}
+	if receiver.Token == "" {
+		return nil, fmt.Errorf("receiver %s: token must be provided", receiver.Name)
+	}
+	return &WeCom{receiverBase{receiver: receiver}}, nil
+}
+
+func (w *WeCom) Send(msg *Message) (*Result, error) {
+	var sendData any
+	if msg.Type == "text" {
+		sendData = WeComTextMessage{
+			MsgType: "text",
+			Text:    WeComText{Content: msg.Content},
+		}
+	} else {
+		sendData = WeComMarkdownMessage{
+			MsgType:  "markdown",
+			Markdown: WeComMarkdown{Content: msg.Content},
+		}
+	}
+
+	sendDataBytes, err := json.Marshal(sendData)
+	if err != nil {
+		logger.Errorf("Failed to marshal JSON: %v", err)
+		return nil, err
+	}
+	logger.Debug(string(sendDataBytes))
+
+	statusCode, respBody, err := postJSON(w.receiver.WebhookURL+string(w.receiver.Token), sendDataBytes, nil)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("发送企业微信响应 %s", respBody)
+	return &Result{Receiver: w.Name(), StatusCode: statusCode, RespMsg: respBody}, nil
+}
@@ -4,25 +4,45 @@ import (
 	"alert_gateway/apps"
 	"alert_gateway/config"
 	"alert_gateway/logger"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
 )
 
 func main() {
 	var help bool
+	var checkConfig bool
+	var dumpConfig bool
 	var configPath string
 
 	flag.BoolVar(&help, "help", false, "show help informaction")
+	flag.BoolVar(&checkConfig, "check-config", false, "check config file and exit")
+	flag.BoolVar(&dumpConfig, "dump-config", false, "print the effective config with secrets masked and exit")
 	flag.StringVar(&configPath, "config", "config.toml", "path to config file")
 
 	flag.Parse()
 
+	// 只检查配置文件，打印所有错误，有错误时返回非0
+	if checkConfig {
+		os.Exit(check(configPath))
+	}
+
 	// 加载配置
 	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// 打印展开环境变量和默认值后的配置，密钥和请求头的值显示为 <secret>
+	if dumpConfig {
+		if err := cfg.Dump(os.Stdout); err != nil {
+			log.Fatalf("Failed to dump config: %v", err)
+		}
+		return
+	}
+
 	// 初始化日志
 	logger.InitLogger(cfg)
 
@@ -31,8 +51,36 @@ func main() {
 		flag.PrintDefaults()
 	default:
 		// 实例化应用，传入配置信息
-		app := apps.NewApp(cfg)
+		app, err := apps.NewApp(cfg)
+		if err != nil {
+			log.Fatalf("Failed to create app: %v", err)
+		}
 		// 启动应用
 		app.Run()
 	}
 }
+
+// 检查配置文件，返回退出码
+func check(configPath string) int {
+	cfg, err := config.LoadConfig(configPath)
+	if err == nil {
+		err = apps.CheckConfig(cfg)
+	}
+	if err == nil {
+		fmt.Printf("%s: config ok\n", configPath)
+		return 0
+	}
+	var errs config.Errors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			if e.Line > 0 {
+				fmt.Fprintf(os.Stderr, "%s:%d: %s: %s\n", configPath, e.Line, e.Key, e.Msg)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %s: %s\n", configPath, e.Key, e.Msg)
+			}
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+	}
+	return 1
+}
@@ -2,26 +2,352 @@ package config
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
-	App map[string]any
-	Log map[string]any
+	// 配置文件的路径，重新加载配置时使用
+	Path         string        `toml:"-"`
+	App          App           `toml:"app"`
+	Log          Log           `toml:"log"`
+	Receivers    []Receiver    `toml:"receivers"`
+	Route        *Route        `toml:"route"`
+	InhibitRules []InhibitRule `toml:"inhibit_rules"`
+	Inhibit      Inhibit       `toml:"inhibit"`
+	Templates    Templates     `toml:"templates"`
+	Queue        Queue         `toml:"queue"`
+	Dedup        Dedup         `toml:"dedup"`
+	Flapping     Flapping      `toml:"flapping"`
+	Silences     Silences      `toml:"silences"`
+	Auth         Auth          `toml:"auth"`
+	History      History       `toml:"history"`
+	Escalation   Escalation    `toml:"escalation"`
+	Schedules    []Schedule    `toml:"schedules"`
 }
 
+// 应用配置
+// webhook_url、token、secret 为旧的配置方式，没有配置 [[receivers]] 时使用这些配置创建一个名为 default 的钉钉接收者
+type App struct {
+	Listen      string `toml:"listen"`
+	Port        int    `toml:"port"`
+	WebhookURL  string `toml:"webhook_url"`
+	Token       Secret `toml:"token"`
+	TokenFile   string `toml:"token_file"`
+	Secret      Secret `toml:"secret"`
+	SecretFile  string `toml:"secret_file"`
+	MessageType string `toml:"messageType"`
+	// 默认的接收者，不配置时使用第一个接收者
+	Receiver string `toml:"receiver"`
+	// 分组方式，alert 每条报警发送一条消息，payload 每次推送的报警合并成一条消息
+	GroupBy         string `toml:"group_by"`
+	TLSCertFile     string `toml:"tls_cert_file"`
+	TLSKeyFile      string `toml:"tls_key_file"`
+	TLSClientCAFile string `toml:"tls_client_ca_file"`
+	// 退出时等待正在处理的请求的时间，以及退出前等待负载均衡摘掉流量的时间
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+	ShutdownDelay   time.Duration `toml:"shutdown_delay"`
+	// 就绪检查时是否检查 webhook 主机能否连接
+	ReadinessCheckWebhooks bool `toml:"readiness_check_webhooks"`
+}
+
+// 日志配置，type 为 console、file 或 all，level 为 debug、info 或 error
+type Log struct {
+	Type  string `toml:"type"`
+	Path  string `toml:"path"`
+	Level string `toml:"level"`
+}
+
+// 接收者配置，每个接收者对应一个通知后端（钉钉、企业微信、飞书、Slack、通用webhook）
+type Receiver struct {
+	Name        string            `toml:"name"`
+	Type        string            `toml:"type"`
+	WebhookURL  string            `toml:"webhook_url"`
+	Token       Secret            `toml:"token"`
+	TokenFile   string            `toml:"token_file"`
+	Secret      Secret            `toml:"secret"`
+	SecretFile  string            `toml:"secret_file"`
+	MessageType string            `toml:"messageType"`
+	Template    string            `toml:"template"`
+	Headers     map[string]Secret `toml:"headers"` // 请求头中通常有 Authorization 等凭证，导出配置时隐藏
+	// 消息内容的最大字节数，不配置时使用各个后端的限制
+	MaxMessageSize int `toml:"max_message_size"`
+	// 每分钟最多发送的消息数量，不配置时钉钉和企业微信为20，小于0时不限流
+	RateLimit int `toml:"rate_limit"`
+	// 令牌桶的容量，不配置时为 rate_limit 的一半
+	RateBurst int `toml:"rate_burst"`
+	// 钉钉机器人安全设置中的自定义关键词，消息中没有关键词时加在消息开头
+	Keyword string `toml:"keyword"`
+	// 钉钉消息中@的人
+	At At `toml:"at"`
+	// 限流汇总消息的类型，为 feedCard 时钉钉用 FeedCard 发送汇总消息，每条消息一个链接，不配置时和 messageType 相同
+	DigestType string `toml:"digest_type"`
+}
+
+// 钉钉消息中@的人，只在有故障报警时@
+// mobile_labels 和 user_id_labels 为报警标签或注解的名称，值为手机号或用户id，多个用逗号分隔；
+// mobiles、user_ids、is_at_all 为固定@的人；severity 按报警的 severity 标签@值班人员
+type At struct {
+	MobileLabels []string            `toml:"mobile_labels"`
+	UserIDLabels []string            `toml:"user_id_labels"`
+	Severity     map[string]AtTarget `toml:"severity"`
+	AtTarget
+}
+
+// @的手机号、用户id、值班表当前的值班人员和是否@所有人
+type AtTarget struct {
+	Mobiles   []string `toml:"mobiles"`
+	UserIDs   []string `toml:"user_ids"`
+	Schedules []string `toml:"schedules"`
+	IsAtAll   bool     `toml:"is_at_all"`
+}
+
+// 是否配置了@的人
+func (t *AtTarget) Configured() bool {
+	return len(t.Mobiles) > 0 || len(t.UserIDs) > 0 || len(t.Schedules) > 0 || t.IsAtAll
+}
+
+// 路由配置，和 alertmanager 的路由树一致
+// match 按标签值精确匹配，match_re 按正则匹配，continue 为 true 时匹配后继续匹配后面的兄弟路由
+type Route struct {
+	Receiver string            `toml:"receiver"`
+	Match    map[string]string `toml:"match"`
+	MatchRE  map[string]string `toml:"match_re"`
+	Continue bool              `toml:"continue"`
+	Routes   []*Route          `toml:"routes"`
+}
+
+// 抑制规则，和 alertmanager 的 inhibit_rules 一致
+// 有匹配 source_match、source_match_re 的故障报警时，匹配 target_match、target_match_re 并且 equal 中的标签值和源报警相同的报警不发送，直到源报警恢复
+type InhibitRule struct {
+	SourceMatch   map[string]string `toml:"source_match"`
+	SourceMatchRE map[string]string `toml:"source_match_re"`
+	TargetMatch   map[string]string `toml:"target_match"`
+	TargetMatchRE map[string]string `toml:"target_match_re"`
+	Equal         []string          `toml:"equal"`
+}
+
+// 抑制配置，源报警在 endsAt 之后停止抑制，没有 endsAt 时在最后一次收到后 source_ttl 停止抑制，默认 24h
+type Inhibit struct {
+	SourceTTL time.Duration `toml:"source_ttl"`
+}
+
+// 消息模板配置
+// files 为模板文件列表，支持通配符；markdown 和 text 为两种消息类型默认使用的模板名称，
+// group_markdown 和 group_text 为分组发送时使用的模板名称
+type Templates struct {
+	Files         []string `toml:"files"`
+	Timezone      string   `toml:"timezone"`
+	Markdown      string   `toml:"markdown"`
+	Text          string   `toml:"text"`
+	GroupMarkdown string   `toml:"group_markdown"`
+	GroupText     string   `toml:"group_text"`
+}
+
+// 重试队列配置，path 为空时不启用重试队列
+type Queue struct {
+	Path           string        `toml:"path"`
+	MaxAttempts    int           `toml:"max_attempts"`
+	InitialBackoff time.Duration `toml:"initial_backoff"`
+	MaxBackoff     time.Duration `toml:"max_backoff"`
+}
+
+// 去重配置，ttl 内同一个报警的故障通知只发送一次，ttl 为 0 时不去重
+type Dedup struct {
+	TTL time.Duration `toml:"ttl"`
+}
+
+// 抖动检测配置，window 时间内同一个报警的状态变化达到 threshold 次时认为报警在抖动，
+// 发送一条抖动通知后不再发送，直到 stable 时间内状态没有变化，window 或 threshold 为 0 时不检测
+type Flapping struct {
+	Window    time.Duration `toml:"window"`
+	Threshold int           `toml:"threshold"`
+	Stable    time.Duration `toml:"stable"`
+}
+
+// 静默配置，path 为保存静默的文件，默认为 silences.json
+type Silences struct {
+	Path string `toml:"path"`
+}
+
+// 报警历史配置，path 为空时不保存报警历史，retention 为保留的时间，默认 30 天
+type History struct {
+	Path      string        `toml:"path"`
+	Retention time.Duration `toml:"retention"`
+}
+
+// 值班表，成员按 rotation 轮换，start 当天的 handoff 时间由第一个成员开始值班
+// rotation 为 weekly 时每周在 start 对应的星期几交接，为 daily 时每天交接，overrides 为临时替班
+type Schedule struct {
+	Name      string             `toml:"name"`
+	Timezone  string             `toml:"timezone"`
+	Rotation  string             `toml:"rotation"`
+	Start     string             `toml:"start"`
+	Handoff   string             `toml:"handoff"`
+	Members   []ScheduleMember   `toml:"members"`
+	Overrides []ScheduleOverride `toml:"overrides"`
+}
+
+// 值班人员，mobile 和 user_id 用于钉钉@
+type ScheduleMember struct {
+	Name   string `toml:"name"`
+	Mobile string `toml:"mobile"`
+	UserID string `toml:"user_id"`
+}
+
+// 临时替班，start 到 end 期间由 member 值班
+type ScheduleOverride struct {
+	Member string    `toml:"member"`
+	Start  time.Time `toml:"start"`
+	End    time.Time `toml:"end"`
+}
+
+// 报警升级配置，path 为保存升级进度的文件，默认为 escalations.db，没有配置 policies 时不启用
+type Escalation struct {
+	Path     string             `toml:"path"`
+	Policies []EscalationPolicy `toml:"policies"`
+}
+
+// 一种 severity 的升级策略，故障报警在 after 时间内没有确认或恢复时依次执行每一步
+type EscalationPolicy struct {
+	Severity string           `toml:"severity"`
+	Steps    []EscalationStep `toml:"steps"`
+}
+
+// 升级的一步，after 为从收到故障报警开始的时间，发送给 receiver 并@配置的人，@只支持钉钉接收者
+type EscalationStep struct {
+	After    time.Duration `toml:"after"`
+	Receiver string        `toml:"receiver"`
+	AtTarget
+}
+
+// 接收报警的认证配置，都不配置时不认证
+// 配置了 bearer_token、username/password、hmac_secret 中的任意一种或几种时，请求满足其中一种即可通过，
+// allowed_cidrs 不为空时只接受来自这些网段的请求
+type Auth struct {
+	BearerToken     Secret   `toml:"bearer_token"`
+	BearerTokenFile string   `toml:"bearer_token_file"`
+	Username        string   `toml:"username"`
+	Password        Secret   `toml:"password"`
+	PasswordFile    string   `toml:"password_file"`
+	HMACSecret      Secret   `toml:"hmac_secret"`
+	HMACSecretFile  string   `toml:"hmac_secret_file"`
+	HMACHeader      string   `toml:"hmac_header"`
+	AllowedCIDRs    []string `toml:"allowed_cidrs"`
+}
+
+// 创建带默认值的配置
 func NewConfig() *Config {
 	return &Config{
-		App: make(map[string]any),
-		Log: make(map[string]any),
+		App: App{
+			Listen:          "0.0.0.0",
+			Port:            5000,
+			MessageType:     "markdown",
+			GroupBy:         "alert",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Log: Log{
+			Type:  "console",
+			Level: "info",
+		},
 	}
 }
 
+// 加载并校验配置文件，配置文件中有类型错误、未知的配置项或校验失败时返回 Errors，包含每个错误所在的行号
 func LoadConfig(configPath string) (*Config, error) {
-	config := NewConfig()
-	if _, err := toml.DecodeFile(configPath, &config); err != nil {
-		return nil, fmt.Errorf("error loading config file file :%w", err)
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config file: %w", err)
+	}
+	lines := keyLines(data)
+	config, md, errs, err := decode(data)
+	// 类型错误中的配置项不带下标，换成带下标的配置项
+	for _, e := range errs {
+		if key := lines.keyAt(e.Line); key != "" {
+			e.Key = key
+		}
+	}
+	if err != nil {
+		if len(errs) > 0 {
+			// 去掉类型错误的行之后无法继续解析，只返回已经发现的类型错误
+			return nil, errs
+		}
+		return nil, fmt.Errorf("error loading config file: %w", err)
+	}
+	config.Path = configPath
+
+	// 展开环境变量并从文件读取密钥，需要在设置默认值之前，旧的配置方式的 token 也可以从文件读取
+	for _, e := range config.resolveSecrets() {
+		e.Line = lines.find(e.Key)
+		errs = append(errs, e)
+	}
+	config.setDefaults()
+	// 拼写错误的配置项不会被解析，当作错误处理
+	for _, key := range md.Undecoded() {
+		errs = append(errs, &Error{Line: lines.next(key.String()), Key: key.String(), Msg: "unknown config key"})
+	}
+	for _, e := range config.Validate() {
+		if e.Line == 0 {
+			e.Line = lines.find(e.Key)
+		}
+		errs = append(errs, e)
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Line < errs[j].Line
+		})
+		return nil, errs
 	}
 	return config, nil
 }
+
+// 类型错误的格式，如 toml: line 2 (last key "app.port"): incompatible types: TOML value has type string; destination has type integer
+var typeErrorPattern = regexp.MustCompile(`^toml: line (\d+) \(last key "([^"]*)"\): (.*)$`)
+
+// 解析配置文件，toml 遇到第一个类型错误就停止解析，清空出错的行之后重新解析，收集所有的类型错误
+// 语法错误无法跳过，作为 err 返回
+func decode(data []byte) (*Config, toml.MetaData, Errors, error) {
+	lines := strings.Split(string(data), "\n")
+	var errs Errors
+	for {
+		config := NewConfig()
+		md, err := toml.Decode(strings.Join(lines, "\n"), config)
+		if err == nil {
+			return config, md, errs, nil
+		}
+		match := typeErrorPattern.FindStringSubmatch(err.Error())
+		if match == nil {
+			return nil, md, errs, err
+		}
+		line, _ := strconv.Atoi(match[1])
+		if line < 1 || line > len(lines) || lines[line-1] == "" {
+			return nil, md, errs, err
+		}
+		errs = append(errs, &Error{Line: line, Key: match[2], Msg: match[3]})
+		lines[line-1] = ""
+	}
+}
+
+// 以 toml 格式导出展开环境变量和默认值后的配置，密钥和请求头的值显示为 <secret>
+func (c *Config) Dump(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(c)
+}
+
+// 设置没有配置的默认值
+func (c *Config) setDefaults() {
+	for i := range c.Receivers {
+		if c.Receivers[i].Type == "" {
+			c.Receivers[i].Type = "dingding"
+		}
+		if c.Receivers[i].MessageType == "" {
+			c.Receivers[i].MessageType = c.App.MessageType
+		}
+	}
+}
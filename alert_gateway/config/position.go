@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 配置项所在的行号
+// toml 解析后不保留配置项的位置，这里逐行扫描配置文件记录每个配置项和表所在的行
+type positions struct {
+	// 不带下标的配置项，例如 receivers.name，数组中的每个表各记录一次
+	plain map[string][]int
+	// 带下标的配置项和表，例如 receivers[1].name、receivers[1]
+	indexed map[string]int
+}
+
+func keyLines(data []byte) *positions {
+	p := &positions{plain: make(map[string][]int), indexed: make(map[string]int)}
+	arrays := make(map[string]int)       // 数组表的元素数量，键为带下标的路径
+	lastIndex := make(map[string]string) // 数组表最后一个元素带下标的路径，键为不带下标的路径
+	plainTable, indexedTable := "", ""
+	multiline := ""
+
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(line)
+		// 跳过多行字符串的内容
+		if multiline != "" {
+			if strings.Contains(line, multiline) {
+				multiline = ""
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			array := strings.HasPrefix(line, "[[")
+			name := strings.Trim(stripComment(line), "[] ")
+			parts := splitKey(name)
+			plainTable, indexedTable = "", ""
+			for j, part := range parts {
+				plainTable = joinKey(plainTable, part)
+				if array && j == len(parts)-1 {
+					base := joinKey(indexedTable, part)
+					indexedTable = fmt.Sprintf("%s[%d]", base, arrays[base])
+					arrays[base]++
+					lastIndex[plainTable] = indexedTable
+				} else if last, ok := lastIndex[plainTable]; ok {
+					indexedTable = last
+				} else {
+					indexedTable = joinKey(indexedTable, part)
+				}
+			}
+			p.plain[plainTable] = append(p.plain[plainTable], lineNo)
+			p.indexed[indexedTable] = lineNo
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			continue
+		}
+		key := strings.Join(splitKey(strings.TrimSpace(line[:eq])), ".")
+		value := strings.TrimSpace(line[eq+1:])
+		for _, quote := range []string{`"""`, `'''`} {
+			if strings.HasPrefix(value, quote) && !strings.Contains(value[3:], quote) {
+				multiline = quote
+			}
+		}
+		plainKey := joinKey(plainTable, key)
+		p.plain[plainKey] = append(p.plain[plainKey], lineNo)
+		p.indexed[joinKey(indexedTable, key)] = lineNo
+	}
+	return p
+}
+
+// 返回不带下标的配置项下一次出现的行号，同一个配置项在数组的多个表中出现时按顺序返回
+func (p *positions) next(key string) int {
+	lines := p.plain[key]
+	if len(lines) == 0 {
+		return 0
+	}
+	p.plain[key] = lines[1:]
+	return lines[0]
+}
+
+// 返回带下标的配置项所在的行号，配置项不存在时返回所在的表的行号
+func (p *positions) find(key string) int {
+	for key != "" {
+		if line, ok := p.indexed[key]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return 0
+}
+
+// 返回行号所在的带下标的配置项，没有时返回空字符串
+func (p *positions) keyAt(line int) string {
+	for key, l := range p.indexed {
+		if l == line {
+			return key
+		}
+	}
+	return ""
+}
+
+// 按 . 分割键，去掉引号
+func splitKey(key string) []string {
+	var parts []string
+	for _, part := range strings.Split(key, ".") {
+		parts = append(parts, strings.Trim(strings.TrimSpace(part), `"'`))
+	}
+	return parts
+}
+
+func joinKey(table, key string) string {
+	if table == "" {
+		return key
+	}
+	return table + "." + key
+}
+
+// 去掉表头后面的注释
+func stripComment(line string) string {
+	if i := strings.Index(line, "#"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// 密钥，打印、转成 json 或 toml 时显示为 <secret>，避免密钥出现在日志和导出的配置中
+type Secret string
+
+const maskedSecret = "<secret>"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return maskedSecret
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// 环境变量的引用，只支持 ${NAME} 的格式，避免模板和正则中的 $ 被误当作环境变量
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// 展开配置中的环境变量，并从 *_file 指定的文件读取密钥
+func (c *Config) resolveSecrets() Errors {
+	var errs Errors
+	expandEnv(reflect.ValueOf(c).Elem(), "", &errs)
+
+	add := func(key, format string, args ...any) {
+		errs = append(errs, &Error{Key: key, Msg: fmt.Sprintf(format, args...)})
+	}
+	readSecretFile(&c.App.Token, c.App.TokenFile, "app.token", add)
+	readSecretFile(&c.App.Secret, c.App.SecretFile, "app.secret", add)
+	for i := range c.Receivers {
+		key := fmt.Sprintf("receivers[%d]", i)
+		readSecretFile(&c.Receivers[i].Token, c.Receivers[i].TokenFile, key+".token", add)
+		readSecretFile(&c.Receivers[i].Secret, c.Receivers[i].SecretFile, key+".secret", add)
+	}
+	readSecretFile(&c.Auth.BearerToken, c.Auth.BearerTokenFile, "auth.bearer_token", add)
+	readSecretFile(&c.Auth.Password, c.Auth.PasswordFile, "auth.password", add)
+	readSecretFile(&c.Auth.HMACSecret, c.Auth.HMACSecretFile, "auth.hmac_secret", add)
+	return errs
+}
+
+// 从文件读取密钥，去掉首尾的空白，不能同时配置密钥和密钥文件
+func readSecretFile(secret *Secret, file, key string, add func(key, format string, args ...any)) {
+	if file == "" {
+		return
+	}
+	if *secret != "" {
+		name := key[strings.LastIndex(key, ".")+1:]
+		add(key+"_file", "%s and %s_file must not be provided together", name, name)
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		add(key+"_file", "read secret file: %v", err)
+		return
+	}
+	*secret = Secret(strings.TrimSpace(string(data)))
+}
+
+// 递归展开字符串中的环境变量，key 为配置项的路径，用于报告未定义的环境变量
+func expandEnv(v reflect.Value, key string, errs *Errors) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandEnv(v.Elem(), key, errs)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("toml"), ",")[0]
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if field.Anonymous && name == "" {
+				// 嵌入的结构体和外层的配置项在同一个表中
+				expandEnv(v.Field(i), key, errs)
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			expandEnv(v.Field(i), joinKey(key, name), errs)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandEnv(v.Index(i), fmt.Sprintf("%s[%d]", key, i), errs)
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		for _, k := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.SetString(expandString(v.MapIndex(k).String(), key, errs))
+			v.SetMapIndex(k, value)
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(expandString(v.String(), key, errs))
+		}
+	}
+}
+
+// 把 ${NAME} 替换为环境变量的值，环境变量未定义时报告错误
+func expandString(s, key string, errs *Errors) string {
+	return envPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			*errs = append(*errs, &Error{Key: key, Msg: fmt.Sprintf("environment variable %s is not set", name)})
+		}
+		return value
+	})
+}
@@ -0,0 +1,343 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 配置错误，Line 为错误所在的行号，无法确定时为 0
+type Error struct {
+	Line int
+	Key  string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Key, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Key, e.Msg)
+}
+
+// 配置中的所有错误
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// 支持的接收者类型
+var receiverTypes = map[string]bool{
+	"dingding": true,
+	"wecom":    true,
+	"feishu":   true,
+	"lark":     true,
+	"slack":    true,
+	"webhook":  true,
+}
+
+// 支持的消息类型
+var messageTypes = map[string]bool{
+	"markdown":   true,
+	"text":       true,
+	"actionCard": true,
+}
+
+// 校验配置，返回所有的错误
+func (c *Config) Validate() Errors {
+	var errs Errors
+	add := func(key, format string, args ...any) {
+		errs = append(errs, &Error{Key: key, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if c.App.Port <= 0 || c.App.Port > 65535 {
+		add("app.port", "port must be between 1 and 65535, got %d", c.App.Port)
+	}
+	if !messageTypes[c.App.MessageType] {
+		add("app.messageType", "unknown message type %q", c.App.MessageType)
+	}
+	if c.App.GroupBy != "alert" && c.App.GroupBy != "payload" {
+		add("app.group_by", "group_by must be alert or payload, got %q", c.App.GroupBy)
+	}
+	if (c.App.TLSCertFile == "") != (c.App.TLSKeyFile == "") {
+		add("app.tls_cert_file", "tls_cert_file and tls_key_file must be provided together")
+	}
+	if c.App.TLSClientCAFile != "" && c.App.TLSCertFile == "" {
+		add("app.tls_client_ca_file", "tls_client_ca_file requires tls_cert_file and tls_key_file")
+	}
+	if c.App.ShutdownTimeout < 0 {
+		add("app.shutdown_timeout", "shutdown_timeout must not be negative")
+	}
+	if c.App.ShutdownDelay < 0 {
+		add("app.shutdown_delay", "shutdown_delay must not be negative")
+	}
+
+	switch c.Log.Type {
+	case "console":
+	case "file", "all":
+		if c.Log.Path == "" {
+			add("log.path", "path must be provided when log type is %s", c.Log.Type)
+		}
+	default:
+		add("log.type", "log type must be console, file or all, got %q", c.Log.Type)
+	}
+	switch c.Log.Level {
+	case "debug", "info", "error":
+	default:
+		add("log.level", "log level must be debug, info or error, got %q", c.Log.Level)
+	}
+
+	// 值班表
+	schedules := make(map[string]bool)
+	for i, schedule := range c.Schedules {
+		schedule.validate(fmt.Sprintf("schedules[%d]", i), schedules, add)
+		schedules[schedule.Name] = true
+	}
+	checkSchedules := func(key string, names []string) {
+		for _, name := range names {
+			if !schedules[name] {
+				add(key, "unknown schedule %s", name)
+			}
+		}
+	}
+
+	// 接收者
+	names := make(map[string]bool)
+	types := make(map[string]string)
+	if len(c.Receivers) == 0 {
+		// 旧的配置方式，使用 [app] 中的钉钉配置
+		names["default"] = true
+		types["default"] = "dingding"
+		if c.App.Token == "" {
+			add("app.token", "token must be provided when no receivers are configured")
+		}
+	}
+	for i, receiver := range c.Receivers {
+		key := fmt.Sprintf("receivers[%d]", i)
+		switch {
+		case receiver.Name == "":
+			add(key+".name", "receiver name must be provided")
+		case names[receiver.Name]:
+			add(key+".name", "duplicate receiver name %s", receiver.Name)
+		}
+		names[receiver.Name] = true
+		types[receiver.Name] = receiver.Type
+
+		if !receiverTypes[receiver.Type] {
+			add(key+".type", "unknown receiver type %q", receiver.Type)
+		}
+		if !messageTypes[receiver.MessageType] {
+			add(key+".messageType", "unknown message type %q", receiver.MessageType)
+		}
+		switch receiver.Type {
+		case "dingding", "wecom", "feishu", "lark":
+			if receiver.Token == "" {
+				add(key+".token", "token must be provided for %s receivers", receiver.Type)
+			}
+		case "slack", "webhook":
+			if receiver.WebhookURL == "" {
+				add(key+".webhook_url", "webhook_url must be provided for %s receivers", receiver.Type)
+			}
+		}
+		if receiver.WebhookURL != "" {
+			if u, err := url.Parse(receiver.WebhookURL); err != nil || u.Host == "" {
+				add(key+".webhook_url", "invalid url %q", receiver.WebhookURL)
+			}
+		}
+		if receiver.Type != "dingding" && (receiver.Keyword != "" || receiver.At.configured()) {
+			add(key+".keyword", "keyword and at are only supported by dingding receivers")
+		}
+		checkSchedules(key+".at.schedules", receiver.At.Schedules)
+		for severity, target := range receiver.At.Severity {
+			checkSchedules(key+".at.severity."+severity+".schedules", target.Schedules)
+		}
+		if receiver.Type != "dingding" && receiver.MessageType == "actionCard" {
+			add(key+".messageType", "actionCard is only supported by dingding receivers")
+		}
+		switch receiver.DigestType {
+		case "":
+		case "feedCard":
+			if receiver.Type != "dingding" {
+				add(key+".digest_type", "feedCard is only supported by dingding receivers")
+			}
+		default:
+			add(key+".digest_type", "digest_type must be feedCard, got %q", receiver.DigestType)
+		}
+		if receiver.MaxMessageSize < 0 {
+			add(key+".max_message_size", "max_message_size must not be negative")
+		}
+		if receiver.RateLimit > 0 && receiver.RateBurst > receiver.RateLimit {
+			add(key+".rate_burst", "rate_burst must not exceed rate_limit")
+		}
+	}
+	if c.App.Receiver != "" && !names[c.App.Receiver] {
+		add("app.receiver", "unknown receiver %s", c.App.Receiver)
+	}
+	if c.Route != nil {
+		c.Route.validate("route", names, add)
+	}
+	for i, rule := range c.InhibitRules {
+		rule.validate(fmt.Sprintf("inhibit_rules[%d]", i), add)
+	}
+
+	if (c.Auth.Username == "") != (c.Auth.Password == "") {
+		add("auth.username", "username and password must be provided together")
+	}
+	for _, cidr := range c.Auth.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			add("auth.allowed_cidrs", "invalid cidr %q", cidr)
+		}
+	}
+
+	if c.Queue.MaxAttempts < 0 {
+		add("queue.max_attempts", "max_attempts must not be negative")
+	}
+	if c.Queue.InitialBackoff > 0 && c.Queue.MaxBackoff > 0 && c.Queue.InitialBackoff > c.Queue.MaxBackoff {
+		add("queue.initial_backoff", "initial_backoff must not exceed max_backoff")
+	}
+	if c.Inhibit.SourceTTL < 0 {
+		add("inhibit.source_ttl", "source_ttl must not be negative")
+	}
+	if c.Dedup.TTL < 0 {
+		add("dedup.ttl", "ttl must not be negative")
+	}
+	severities := make(map[string]bool)
+	for i, policy := range c.Escalation.Policies {
+		key := fmt.Sprintf("escalation.policies[%d]", i)
+		switch {
+		case policy.Severity == "":
+			add(key+".severity", "severity must be provided")
+		case severities[policy.Severity]:
+			add(key+".severity", "duplicate escalation policy for severity %s", policy.Severity)
+		}
+		severities[policy.Severity] = true
+		if len(policy.Steps) == 0 {
+			add(key+".steps", "at least one step must be provided")
+		}
+		var last time.Duration
+		for j, step := range policy.Steps {
+			stepKey := fmt.Sprintf("%s.steps[%d]", key, j)
+			if step.After <= last {
+				add(stepKey+".after", "after must be positive and greater than the previous step")
+			}
+			last = step.After
+			switch {
+			case step.Receiver == "":
+				add(stepKey+".receiver", "receiver must be provided")
+			case !names[step.Receiver]:
+				add(stepKey+".receiver", "unknown receiver %s", step.Receiver)
+			case types[step.Receiver] != "dingding" && step.Configured():
+				add(stepKey+".receiver", "mobiles, user_ids, schedules and is_at_all are only supported by dingding receivers")
+			}
+			checkSchedules(stepKey+".schedules", step.Schedules)
+		}
+	}
+	if c.Flapping.Window < 0 || c.Flapping.Stable < 0 || c.Flapping.Threshold < 0 {
+		add("flapping.window", "window, threshold and stable must not be negative")
+	}
+	if c.Flapping.Window > 0 && c.Flapping.Threshold == 1 {
+		add("flapping.threshold", "threshold must be at least 2")
+	}
+	if c.History.Retention < 0 {
+		add("history.retention", "retention must not be negative")
+	}
+	if c.History.Path != "" && c.History.Path == c.Queue.Path {
+		add("history.path", "history and queue must not use the same file")
+	}
+	return errs
+}
+
+// 是否配置了@的人
+func (a *At) configured() bool {
+	return len(a.MobileLabels) > 0 || len(a.UserIDLabels) > 0 || len(a.Severity) > 0 || a.AtTarget.Configured()
+}
+
+// 校验值班表，names 为前面已经出现的值班表名称
+func (s *Schedule) validate(key string, names map[string]bool, add func(key, format string, args ...any)) {
+	switch {
+	case s.Name == "":
+		add(key+".name", "schedule name must be provided")
+	case names[s.Name]:
+		add(key+".name", "duplicate schedule name %s", s.Name)
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		add(key+".timezone", "invalid timezone %q: %v", s.Timezone, err)
+	}
+	if s.Rotation != "" && s.Rotation != "weekly" && s.Rotation != "daily" {
+		add(key+".rotation", "rotation must be weekly or daily, got %q", s.Rotation)
+	}
+	if _, err := time.Parse("2006-01-02", s.Start); err != nil {
+		add(key+".start", "start must be a date like 2024-01-01, got %q", s.Start)
+	}
+	if s.Handoff != "" {
+		if _, err := time.Parse("15:04", s.Handoff); err != nil {
+			add(key+".handoff", "handoff must be a time like 09:00, got %q", s.Handoff)
+		}
+	}
+	if len(s.Members) == 0 {
+		add(key+".members", "at least one member must be provided")
+	}
+	members := make(map[string]bool)
+	for i, member := range s.Members {
+		memberKey := fmt.Sprintf("%s.members[%d]", key, i)
+		switch {
+		case member.Name == "":
+			add(memberKey+".name", "member name must be provided")
+		case members[member.Name]:
+			add(memberKey+".name", "duplicate member %s", member.Name)
+		}
+		members[member.Name] = true
+		if member.Mobile == "" && member.UserID == "" {
+			add(memberKey+".mobile", "mobile or user_id must be provided")
+		}
+	}
+	for i, override := range s.Overrides {
+		overrideKey := fmt.Sprintf("%s.overrides[%d]", key, i)
+		if !members[override.Member] {
+			add(overrideKey+".member", "unknown member %s", override.Member)
+		}
+		if override.Start.IsZero() || !override.End.After(override.Start) {
+			add(overrideKey+".end", "start and end must be provided and end must be after start")
+		}
+	}
+}
+
+// 校验抑制规则，源报警和目标报警都至少需要一个匹配条件
+func (r *InhibitRule) validate(key string, add func(key, format string, args ...any)) {
+	if len(r.SourceMatch) == 0 && len(r.SourceMatchRE) == 0 {
+		add(key+".source_match", "source_match or source_match_re must be provided")
+	}
+	if len(r.TargetMatch) == 0 && len(r.TargetMatchRE) == 0 {
+		add(key+".target_match", "target_match or target_match_re must be provided")
+	}
+	for field, matchRE := range map[string]map[string]string{"source_match_re": r.SourceMatchRE, "target_match_re": r.TargetMatchRE} {
+		for name, value := range matchRE {
+			if _, err := regexp.Compile(value); err != nil {
+				add(key+"."+field, "invalid regex for %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// 校验路由引用的接收者是否存在，正则是否有效
+func (r *Route) validate(key string, receivers map[string]bool, add func(key, format string, args ...any)) {
+	if r.Receiver != "" && !receivers[r.Receiver] {
+		add(key+".receiver", "unknown receiver %s", r.Receiver)
+	}
+	for name, value := range r.MatchRE {
+		if _, err := regexp.Compile(value); err != nil {
+			add(key+".match_re", "invalid regex for %s: %v", name, err)
+		}
+	}
+	for i, child := range r.Routes {
+		child.validate(fmt.Sprintf("%s.routes[%d]", key, i), receivers, add)
+	}
+}
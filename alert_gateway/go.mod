@@ -2,4 +2,18 @@ module alert_gateway
 
 go 1.21.0
 
-require github.com/BurntSushi/toml v1.4.0
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/prometheus/client_golang v1.19.1
+	go.etcd.io/bbolt v1.3.10
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
@@ -7,36 +7,53 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"sync"
 )
 
+// 各个级别的日志，重新加载配置时不替换这三个对象，只替换它们的输出
 var (
-	InfoLogger  *log.Logger
-	ErrorLogger *log.Logger
-	DebugLogger *log.Logger
+	InfoLogger  = log.New(infoOutput, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ErrorLogger = log.New(errorOutput, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+	DebugLogger = log.New(debugOutput, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile)
 )
 
+// 重新加载配置时整体替换日志的输出和打开的日志文件，写日志时持有读锁，替换后旧的日志文件不会再被写入，可以直接关闭
+var (
+	mu          sync.RWMutex
+	infoOutput  = &output{w: os.Stdout}
+	errorOutput = &output{w: os.Stdout}
+	debugOutput = &output{w: io.Discard}
+	logFile     *os.File
+)
+
+// 可以替换的日志输出
+type output struct {
+	w io.Writer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return o.w.Write(p)
+}
+
 func InitLogger(cfg *config.Config) {
 	logConfig := cfg.Log
 
 	// 定义日志输出模式
 	var logOutput io.Writer
+	var file *os.File
 
-	logPath, ok := logConfig["path"].(string)
-	if !ok {
-		logPath = ""
-	}
-
-	logType, ok := logConfig["type"].(string)
-	if !ok {
-		logType = "console"
-	}
+	logPath := logConfig.Path
+	logType := logConfig.Type
 
 	switch logType {
 	case "file":
 		if logPath == "" {
 			log.Fatalf("当日志类型是file时日志的路径必须设置")
 		}
-		file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		var err error
+		file, err = os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 		if err != nil {
 			log.Fatalf("打开日志文件失败: %v", err)
 		}
@@ -46,7 +63,8 @@ func InitLogger(cfg *config.Config) {
 		if logPath == "" {
 			log.Fatalf("当日志类型为all时日志路径必须设置")
 		}
-		file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		var err error
+		file, err = os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 		if err != nil {
 			log.Fatalf("打开日志文件失败: %v", err)
 		}
@@ -60,25 +78,27 @@ func InitLogger(cfg *config.Config) {
 	}
 
 	// 读取配置中指定的日志级别
-	logLevel, ok := logConfig["level"].(string)
-	if !ok {
+	logLevel := logConfig.Level
+	if logLevel == "" {
 		logLevel = "info"
 	}
 
-	// 为不同级别的日志定义格式
-	InfoLogger = log.New(logOutput, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger = log.New(logOutput, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-	DebugLogger = log.New(logOutput, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile)
-
 	// 判断获取的日志级别
+	info, errorLog, debug := logOutput, logOutput, logOutput
 	switch logLevel {
-	case "debug":
-		DebugLogger.SetOutput(logOutput)
 	case "info":
-		DebugLogger.SetOutput(io.Discard)
+		debug = io.Discard
 	case "error":
-		InfoLogger.SetOutput(io.Discard)
-		DebugLogger.SetOutput(io.Discard)
+		info = io.Discard
+		debug = io.Discard
+	}
+
+	mu.Lock()
+	old := logFile
+	infoOutput.w, errorOutput.w, debugOutput.w, logFile = info, errorLog, debug, file
+	mu.Unlock()
+	if old != nil {
+		old.Close()
 	}
 }
 
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticator(t *testing.T) {
+	auth, err := apps.NewAuthenticator(config.Auth{
+		BearerToken:  "token",
+		Username:     "alertmanager",
+		Password:     "password",
+		HMACSecret:   "secret",
+		AllowedCIDRs: []string{"10.0.0.0/8", "192.168.1.10"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+	handler := auth.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	body := `{"alerts":[]}`
+	h := hmac.New(sha256.New, []byte("secret"))
+	h.Write([]byte(body))
+	signature := hex.EncodeToString(h.Sum(nil))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		setup      func(r *http.Request)
+		want       int
+	}{
+		{"bearer", "10.1.2.3:1234", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, http.StatusOK},
+		{"basic", "192.168.1.10:1234", func(r *http.Request) { r.SetBasicAuth("alertmanager", "password") }, http.StatusOK},
+		{"hmac", "10.1.2.3:1234", func(r *http.Request) { r.Header.Set("X-Alert-Gateway-Signature", "sha256="+signature) }, http.StatusOK},
+		{"wrong token", "10.1.2.3:1234", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+		{"no credentials", "10.1.2.3:1234", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"address not allowed", "172.16.0.1:1234", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.RemoteAddr = tt.remoteAddr
+		tt.setup(r)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
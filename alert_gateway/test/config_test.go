@@ -0,0 +1,183 @@
+package test
+
+import (
+	"alert_gateway/config"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := `[app]
+port = 5000
+webhook_ur = "https://oapi.dingtalk.com/robot/send?"
+
+[[receivers]]
+name = "ops"
+token = "token"
+
+[[receivers]]
+name = "slack"
+type = "slack"
+
+[route]
+receiver = "dba"
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := config.LoadConfig(path)
+	var errs config.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("LoadConfig() error = %v, want config.Errors", err)
+	}
+
+	want := []struct {
+		line int
+		key  string
+	}{
+		{3, "app.webhook_ur"},
+		{9, "receivers[1].webhook_url"},
+		{14, "route.receiver"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("LoadConfig() returned %d errors, want %d:\n%v", len(errs), len(want), errs)
+	}
+	for i, w := range want {
+		if errs[i].Line != w.line || errs[i].Key != w.key {
+			t.Errorf("error %d = %v, want line %d key %s", i, errs[i], w.line, w.key)
+		}
+	}
+}
+
+// 类型错误和未知的配置项、校验错误一起返回
+func TestLoadConfigTypeErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := `[app]
+port = "5000"
+webhook_ur = "https://oapi.dingtalk.com/robot/send?"
+
+[[receivers]]
+name = "ops"
+token = "token"
+max_message_size = "20000"
+
+[route]
+receiver = "dba"
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := config.LoadConfig(path)
+	var errs config.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("LoadConfig() error = %v, want config.Errors", err)
+	}
+
+	want := []struct {
+		line int
+		key  string
+	}{
+		{2, "app.port"},
+		{3, "app.webhook_ur"},
+		{8, "receivers[0].max_message_size"},
+		{11, "route.receiver"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("LoadConfig() returned %d errors, want %d:\n%v", len(errs), len(want), errs)
+	}
+	for i, w := range want {
+		if errs[i].Line != w.line || errs[i].Key != w.key {
+			t.Errorf("error %d = %v, want line %d key %s", i, errs[i], w.line, w.key)
+		}
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := `[[receivers]]
+name = "ops"
+token = "token"
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.App.Port != 5000 || cfg.Log.Level != "info" {
+		t.Errorf("defaults not applied: port %d, log level %q", cfg.App.Port, cfg.Log.Level)
+	}
+	if r := cfg.Receivers[0]; r.Type != "dingding" || r.MessageType != "markdown" {
+		t.Errorf("receiver defaults not applied: type %q, messageType %q", r.Type, r.MessageType)
+	}
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretFile, []byte("SEC123\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ALERT_GATEWAY_TEST_TOKEN", "token-from-env")
+	path := filepath.Join(dir, "config.toml")
+	data := `[[receivers]]
+name = "ops"
+token = "${ALERT_GATEWAY_TEST_TOKEN}"
+secret_file = "` + secretFile + `"
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	receiver := cfg.Receivers[0]
+	if string(receiver.Token) != "token-from-env" || string(receiver.Secret) != "SEC123" {
+		t.Errorf("token %q secret %q not resolved", string(receiver.Token), string(receiver.Secret))
+	}
+	// 打印配置时不显示密钥
+	if dump := fmt.Sprintf("%+v", receiver); strings.Contains(dump, "token-from-env") || strings.Contains(dump, "SEC123") {
+		t.Errorf("secret not masked: %s", dump)
+	}
+}
+
+// 导出的配置中隐藏密钥和请求头的值
+func TestDumpConfig(t *testing.T) {
+	t.Setenv("ALERT_GATEWAY_TEST_AUTH", "Bearer abc123")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := `[[receivers]]
+name = "hook"
+type = "webhook"
+webhook_url = "https://hooks.example.com/alerts"
+secret = "SEC123"
+headers = { Authorization = "${ALERT_GATEWAY_TEST_AUTH}" }
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if got := string(cfg.Receivers[0].Headers["Authorization"]); got != "Bearer abc123" {
+		t.Errorf("Authorization = %q, want the expanded value", got)
+	}
+
+	var dump strings.Builder
+	if err := cfg.Dump(&dump); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if strings.Contains(dump.String(), "abc123") || strings.Contains(dump.String(), "SEC123") {
+		t.Errorf("secret not masked:\n%s", dump.String())
+	}
+	if !strings.Contains(dump.String(), `Authorization = "<secret>"`) {
+		t.Errorf("header missing from dump:\n%s", dump.String())
+	}
+}
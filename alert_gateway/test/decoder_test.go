@@ -0,0 +1,111 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDecodeAlerts(t *testing.T) {
+	// alertmanager 的 webhook 根据请求体识别
+	body, err := os.ReadFile("test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alertData, decoder, err := apps.DecodeAlerts("", body)
+	if err != nil || decoder != "alertmanager" || len(alertData.Alerts) == 0 {
+		t.Fatalf("alertmanager: decoder = %s, alerts = %d, error = %v", decoder, len(alertData.Alerts), err)
+	}
+
+	grafana := `{"receiver": "gateway", "status": "firing", "orgId": 1, "title": "[FIRING:1] HighCPU", "state": "alerting",
+		"alerts": [{"status": "firing", "labels": {"instance": "db01"}, "annotations": {"summary": "cpu high"},
+		"values": {"B": 92.5}, "valueString": "[ var='B' value=92.5 ]",
+		"panelURL": "http://grafana/d/abc?viewPanel=2", "silenceURL": "http://grafana/alerting/silence/new"}]}`
+	alertData, decoder, err = apps.DecodeAlerts("", []byte(grafana))
+	if err != nil || decoder != "grafana" {
+		t.Fatalf("grafana: decoder = %s, error = %v", decoder, err)
+	}
+	alert := alertData.Alerts[0]
+	if alert.Values["B"] != 92.5 || alert.PanelURL == "" || alert.Labels["alertname"] != "[FIRING:1] HighCPU" || alertData.OrgID != 1 {
+		t.Errorf("grafana alert = %+v", alert)
+	}
+
+	// alertmanager v2 API 没有状态，endsAt 已经过去的报警为恢复
+	ended := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	v2 := `[{"labels": {"alertname": "disk_usage", "instance": "db01"}},
+		{"labels": {"alertname": "disk_usage", "instance": "db02"}, "endsAt": "` + ended + `"}]`
+	alertData, decoder, err = apps.DecodeAlerts("", []byte(v2))
+	if err != nil || decoder != "alertmanager-v2" {
+		t.Fatalf("v2: decoder = %s, error = %v", decoder, err)
+	}
+	if alertData.Status != "firing" || alertData.Alerts[0].Status != "firing" || alertData.Alerts[1].Status != "resolved" {
+		t.Errorf("v2 statuses = %s %s %s", alertData.Status, alertData.Alerts[0].Status, alertData.Alerts[1].Status)
+	}
+	if len(alertData.CommonLabels) != 1 || alertData.CommonLabels["alertname"] != "disk_usage" {
+		t.Errorf("v2 common labels = %v", alertData.CommonLabels)
+	}
+
+	plain := `{"alertname": "backup_failed", "severity": "critical", "instance": "db01", "summary": "备份失败"}`
+	alertData, decoder, err = apps.DecodeAlerts("", []byte(plain))
+	if err != nil || decoder != "plain" {
+		t.Fatalf("plain: decoder = %s, error = %v", decoder, err)
+	}
+	alert = alertData.Alerts[0]
+	if alert.Status != "firing" || alert.Labels["severity"] != "critical" || alert.Annotations["summary"] != "备份失败" || alert.StartsAt == "" {
+		t.Errorf("plain alert = %+v", alert)
+	}
+
+	// 指定解码器时不再识别格式
+	if _, _, err := apps.DecodeAlerts("plain", []byte(`{"summary": "no name"}`)); err == nil {
+		t.Error("plain alert without alertname accepted")
+	}
+	if _, _, err := apps.DecodeAlerts("nagios", []byte(plain)); err == nil {
+		t.Error("unknown decoder accepted")
+	}
+}
+
+// prometheus 直接推送时状态没有变化的报警只发送一次
+func TestAlertmanagerV2Repeats(t *testing.T) {
+	var sent atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent.Add(1)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer receiver.Close()
+	app := newTestApp(t, `
+[[receivers]]
+name = "hook"
+type = "webhook"
+webhook_url = "`+receiver.URL+`"
+`)
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	ended := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	firing := `[{"labels": {"alertname": "disk_usage", "instance": "db01"}}]`
+	resolved := `[{"labels": {"alertname": "disk_usage", "instance": "db01"}, "endsAt": "` + ended + `"}]`
+	for i, tt := range []struct {
+		body string
+		want int32
+	}{
+		{firing, 1},
+		{firing, 1},
+		{resolved, 2},
+		{resolved, 2},
+		{firing, 3},
+	} {
+		resp, err := http.Post(gateway.URL+"/api/v2/alerts", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if n := sent.Load(); n != tt.want {
+			t.Errorf("post %d: messages sent = %d, want %d", i+1, n, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeduplicator(t *testing.T) {
+	dedup := apps.NewDeduplicator(time.Hour)
+	firing := apps.Alert{Status: "firing", Fingerprint: "df65a5a1f3b2fea6"}
+	resolved := apps.Alert{Status: "resolved", Fingerprint: "df65a5a1f3b2fea6"}
+
+	if !dedup.Reserve(firing) {
+		t.Fatal("first firing notification should not be a duplicate")
+	}
+	if dedup.Reserve(firing) {
+		t.Error("repeated firing notification should be a duplicate")
+	}
+	if !dedup.Reserve(resolved) {
+		t.Error("resolved notification should always go through")
+	}
+	if !dedup.Reserve(firing) {
+		t.Error("firing notification after resolved should go through")
+	}
+	// 发送失败后释放，alertmanager 重新推送时可以再次发送
+	dedup.Release(firing)
+	if !dedup.Reserve(firing) {
+		t.Error("firing notification after release should go through")
+	}
+	if dedup.Dropped() != 1 {
+		t.Errorf("Dropped() = %d, want 1", dedup.Dropped())
+	}
+
+	// 没有 fingerprint 时按标签计算指纹
+	a := apps.Alert{Status: "firing", Labels: map[string]string{"alertname": "cpu", "instance": "10.10.1.21"}}
+	b := apps.Alert{Status: "firing", Labels: map[string]string{"instance": "10.10.1.21", "alertname": "cpu"}}
+	dedup.Reserve(a)
+	if dedup.Reserve(b) {
+		t.Error("alerts with the same labels should have the same fingerprint")
+	}
+}
+
+func TestDeduplicatorTTL(t *testing.T) {
+	dedup := apps.NewDeduplicator(50 * time.Millisecond)
+	firing := apps.Alert{Status: "firing", Fingerprint: "df65a5a1f3b2fea6"}
+	dedup.Reserve(firing)
+	time.Sleep(60 * time.Millisecond)
+	if !dedup.Reserve(firing) {
+		t.Error("firing notification after ttl should go through")
+	}
+}
+
+// 高可用的两个 alertmanager 同时推送同一个报警，接收者很慢时也只发送一次
+func TestDedupConcurrentPosts(t *testing.T) {
+	var sent atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent.Add(1)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer receiver.Close()
+
+	app := newTestApp(t, `
+[dedup]
+ttl = "1h"
+
+[[receivers]]
+name = "hook"
+type = "webhook"
+webhook_url = "`+receiver.URL+`"
+`)
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	body := `{"status":"firing","alerts":[{"status":"firing","fingerprint":"df65a5a1f3b2fea6","labels":{"alertname":"cpu_temperature_max"}}]}`
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(gateway.URL+"/", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if n := sent.Load(); n != 1 {
+		t.Errorf("messages sent = %d, want 1", n)
+	}
+}
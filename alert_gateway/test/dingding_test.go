@@ -0,0 +1,193 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDingTalkErrcode(t *testing.T) {
+	tests := []struct {
+		body string
+		want error
+	}{
+		{`{"errcode":310000,"errmsg":"keywords not in content"}`, apps.ErrDingTalkSecurity},
+		{`{"errcode":130101,"errmsg":"send too fast"}`, apps.ErrDingTalkRateLimited},
+		{`{"errcode":0,"errmsg":"ok"}`, nil},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("access_token") != "token" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, tt.body)
+		}))
+
+		notifier, err := apps.NewNotifier(config.Receiver{Name: "ops", Type: "dingding", WebhookURL: server.URL + "/robot/send?", Token: "token"})
+		if err != nil {
+			t.Fatalf("Failed to create notifier: %v", err)
+		}
+		result, err := notifier.Send(&apps.Message{Title: "故障", Content: "test", Type: "markdown"})
+		server.Close()
+
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Send() error = %v, want %v", err, tt.want)
+		}
+		var dingErr *apps.DingTalkError
+		if tt.want != nil && (!errors.As(err, &dingErr) || dingErr.Code != result.ErrCode) {
+			t.Errorf("expected DingTalkError with code %d, got %v", result.ErrCode, err)
+		}
+	}
+}
+
+func TestDingTalkMentions(t *testing.T) {
+	var payload struct {
+		Markdown struct {
+			Text string `json:"text"`
+		} `json:"markdown"`
+		At struct {
+			AtMobiles []string `json:"atMobiles"`
+			AtUserIds []string `json:"atUserIds"`
+			IsAtAll   bool     `json:"isAtAll"`
+		} `json:"at"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+	}))
+	defer server.Close()
+
+	receiver := config.Receiver{Name: "ops", Type: "dingding", WebhookURL: server.URL + "/robot/send?", Token: "token", Keyword: "报警"}
+	receiver.At.MobileLabels = []string{"owner_mobile"}
+	receiver.At.Severity = map[string]config.AtTarget{"critical": {UserIDs: []string{"oncall01"}}}
+	notifier, err := apps.NewNotifier(receiver)
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+
+	alerts := []apps.Alert{
+		{Status: "firing", Labels: map[string]string{"severity": "critical", "owner_mobile": "13800000000,13900000000"}},
+		{Status: "resolved", Labels: map[string]string{"owner_mobile": "13700000000"}},
+	}
+	if _, err := notifier.Send(&apps.Message{Content: "cpu temperature", Type: "markdown", Alerts: alerts}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if got := strings.Join(payload.At.AtMobiles, ","); got != "13800000000,13900000000" {
+		t.Errorf("atMobiles = %s", got)
+	}
+	if got := strings.Join(payload.At.AtUserIds, ","); got != "oncall01" {
+		t.Errorf("atUserIds = %s", got)
+	}
+	for _, want := range []string{"报警", "@13800000000", "@oncall01"} {
+		if !strings.Contains(payload.Markdown.Text, want) {
+			t.Errorf("text %q does not contain %q", payload.Markdown.Text, want)
+		}
+	}
+
+	// 只有恢复报警时不@
+	payload.At.AtMobiles = nil
+	if _, err := notifier.Send(&apps.Message{Content: "报警恢复", Type: "markdown", Alerts: alerts[1:]}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(payload.At.AtMobiles) != 0 {
+		t.Errorf("resolved alerts mentioned %v", payload.At.AtMobiles)
+	}
+}
+
+func TestDingTalkActionCard(t *testing.T) {
+	var payload struct {
+		MsgType    string `json:"msgtype"`
+		ActionCard struct {
+			Title string `json:"title"`
+			Btns  []struct {
+				Title     string `json:"title"`
+				ActionURL string `json:"actionURL"`
+			} `json:"btns"`
+		} `json:"actionCard"`
+		FeedCard struct {
+			Links []struct {
+				Title      string `json:"title"`
+				MessageURL string `json:"messageURL"`
+			} `json:"links"`
+		} `json:"feedCard"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+	}))
+	defer server.Close()
+
+	receiver := config.Receiver{Name: "ops", Type: "dingding", WebhookURL: server.URL + "/robot/send?", Token: "token"}
+	notifier, err := apps.NewNotifier(receiver)
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+
+	alerts := []apps.Alert{
+		{
+			Status:       "firing",
+			Labels:       map[string]string{"alertname": "HighCPU", "instance": "a"},
+			Annotations:  map[string]string{"runbook_url": "https://wiki/cpu"},
+			GeneratorURL: "http://prometheus/graph?g0.expr=cpu",
+		},
+		{Status: "firing", Labels: map[string]string{"alertname": "HighCPU", "instance": "b"}},
+	}
+	msg := &apps.Message{Title: "故障", Content: "cpu", Type: "actionCard", Alerts: alerts, ExternalURL: "http://alertmanager:9093/"}
+	if _, err := notifier.Send(msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if payload.MsgType != "actionCard" || payload.ActionCard.Title != "故障" {
+		t.Fatalf("msgtype = %s, title = %s", payload.MsgType, payload.ActionCard.Title)
+	}
+	want := []string{
+		"http://prometheus/graph?g0.expr=cpu",
+		"https://wiki/cpu",
+		"http://alertmanager:9093/#/silences/new?filter=%7Balertname%3D%22HighCPU%22%7D",
+	}
+	if len(payload.ActionCard.Btns) != len(want) {
+		t.Fatalf("btns = %+v", payload.ActionCard.Btns)
+	}
+	for i, url := range want {
+		if payload.ActionCard.Btns[i].ActionURL != url {
+			t.Errorf("btns[%d] = %s, want %s", i, payload.ActionCard.Btns[i].ActionURL, url)
+		}
+	}
+
+	// 没有按钮时改为 markdown 消息
+	msg = &apps.Message{Title: "故障", Content: "cpu", Type: "actionCard", Alerts: alerts[1:]}
+	if _, err := notifier.Send(msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if payload.MsgType != "markdown" {
+		t.Errorf("msgtype = %s, want markdown", payload.MsgType)
+	}
+
+	// FeedCard 汇总消息，每条消息一个链接
+	digest := &apps.Message{Title: "报警汇总(2)", Content: "digest", Type: "feedCard", Items: []*apps.Message{
+		{Title: "故障", Alerts: alerts},
+		{Title: "恢复", Alerts: alerts[1:], ExternalURL: "http://alertmanager:9093"},
+	}}
+	if _, err := notifier.Send(digest); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if payload.MsgType != "feedCard" || len(payload.FeedCard.Links) != 2 {
+		t.Fatalf("msgtype = %s, links = %+v", payload.MsgType, payload.FeedCard.Links)
+	}
+	if link := payload.FeedCard.Links[0]; link.Title != "故障 HighCPU 等2条报警" || link.MessageURL != alerts[0].GeneratorURL {
+		t.Errorf("links[0] = %+v", link)
+	}
+	if link := payload.FeedCard.Links[1]; link.MessageURL != "http://alertmanager:9093" {
+		t.Errorf("links[1] = %+v", link)
+	}
+}
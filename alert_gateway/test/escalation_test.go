@@ -0,0 +1,61 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEscalations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "escalations.db")
+	escalations, err := apps.OpenEscalations(path)
+	if err != nil {
+		t.Fatalf("Failed to open escalations: %v", err)
+	}
+
+	policy := &config.EscalationPolicy{Severity: "critical", Steps: []config.EscalationStep{
+		{After: 10 * time.Minute, Receiver: "ops"},
+		{After: 20 * time.Minute, Receiver: "managers"},
+	}}
+	alert := apps.Alert{Status: "firing", Fingerprint: "cpu", Labels: map[string]string{"alertname": "cpu_temperature_max", "severity": "critical"}}
+	if err := escalations.Start(alert, "http://alertmanager:9093", policy); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	// alertmanager 重新发送的故障报警不会重新开始升级
+	started := time.Now()
+	if err := escalations.Start(alert, "http://alertmanager:9093", policy); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// 重启后升级进度仍然存在
+	escalations.Close()
+	if escalations, err = apps.OpenEscalations(path); err != nil {
+		t.Fatalf("Failed to reopen escalations: %v", err)
+	}
+	defer escalations.Close()
+	states, err := escalations.List()
+	if err != nil || len(states) != 1 {
+		t.Fatalf("List() = %v, %v", states, err)
+	}
+	if next := states[0].NextAt; next.After(started.Add(10*time.Minute)) || states[0].Step != 0 {
+		t.Errorf("step = %d, nextAt = %s", states[0].Step, next)
+	}
+
+	state, err := escalations.Ack("cpu", "zhangsan")
+	if err != nil || state.AckedBy != "zhangsan" {
+		t.Fatalf("Ack() = %+v, %v", state, err)
+	}
+	if _, err := escalations.Ack("cpu", "lisi"); err == nil {
+		t.Error("acknowledged twice")
+	}
+
+	alert.Status = "resolved"
+	if err := escalations.Resolve(alert); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if states, _ = escalations.List(); len(states) != 0 {
+		t.Errorf("escalations after resolve = %v", states)
+	}
+}
@@ -0,0 +1,44 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"testing"
+	"time"
+)
+
+func TestFlapDetector(t *testing.T) {
+	detector := apps.NewFlapDetector(config.Flapping{Window: time.Minute, Threshold: 3})
+	alert := apps.Alert{Fingerprint: "cpu", Labels: map[string]string{"alertname": "cpu_temperature_max"}}
+	other := apps.Alert{Status: "firing", Fingerprint: "disk", Labels: map[string]string{"alertname": "disk_usage"}}
+
+	// 第一次收到不算状态变化，之后每次故障和恢复交替算一次
+	for i, status := range []string{"firing", "resolved", "firing"} {
+		alert.Status = status
+		if detector.Observe(alert, "") {
+			t.Fatalf("flapping after %d alerts", i+1)
+		}
+	}
+	alert.Status = "resolved"
+	if !detector.Observe(alert, "") {
+		t.Fatal("not flapping after 3 state changes")
+	}
+	// 已经在抖动的报警不再返回 true
+	alert.Status = "firing"
+	if detector.Observe(alert, "") {
+		t.Error("flapping notice sent twice")
+	}
+	if !detector.Flapping(alert) || detector.Count() != 1 {
+		t.Errorf("Flapping() = %v, Count() = %d", detector.Flapping(alert), detector.Count())
+	}
+
+	// 状态不变的报警不会抖动
+	for i := 0; i < 5; i++ {
+		if detector.Observe(other, "") {
+			t.Fatal("alert without state changes is flapping")
+		}
+	}
+	if detector.Flapping(other) {
+		t.Error("disk_usage is flapping")
+	}
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 就绪检查的响应
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func getReadyz(t *testing.T, url string) (int, readiness) {
+	code, body := getURL(t, url+"/readyz")
+	var ready readiness
+	if err := json.Unmarshal([]byte(body), &ready); err != nil {
+		t.Fatalf("decode readyz %s: %v", body, err)
+	}
+	return code, ready
+}
+
+func TestHealthz(t *testing.T) {
+	rec := newRecorder(t)
+	app := newTestApp(t, `
+[[receivers]]
+name = "ops"
+type = "dingding"
+token = "token"
+webhook_url = "`+rec.URL+`/?"
+`)
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	if code, body := getURL(t, gateway.URL+"/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("healthz = %d %q", code, body)
+	}
+	// 启动之前还不能接收流量
+	if code, ready := getReadyz(t, gateway.URL); code != http.StatusServiceUnavailable || ready.Checks["server"] == "" {
+		t.Errorf("readyz = %d %+v before start", code, ready)
+	}
+	app.Start()
+	defer app.Stop()
+	if code, ready := getReadyz(t, gateway.URL); code != http.StatusOK || ready.Status != "ready" || ready.Checks["config"] != "ok" || ready.Checks["log"] != "ok" {
+		t.Errorf("readyz = %d %+v", code, ready)
+	}
+}
+
+// 开启 webhook 检查时连接不上的接收者返回未就绪
+func TestReadyzWebhooks(t *testing.T) {
+	rec := newRecorder(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+	app := newTestApp(t, `
+[app]
+readiness_check_webhooks = true
+
+[[receivers]]
+name = "ops"
+type = "dingding"
+token = "token"
+webhook_url = "`+rec.URL+`/?"
+
+[[receivers]]
+name = "dba"
+type = "webhook"
+webhook_url = "http://`+closed+`/alerts"
+`)
+	app.Start()
+	defer app.Stop()
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	code, ready := getReadyz(t, gateway.URL)
+	if code != http.StatusServiceUnavailable || ready.Status != "not ready" {
+		t.Errorf("readyz = %d %+v", code, ready)
+	}
+	if ready.Checks["webhook ops"] != "ok" || ready.Checks["webhook dba"] == "ok" || ready.Checks["webhook dba"] == "" {
+		t.Errorf("checks = %+v", ready.Checks)
+	}
+}
+
+// 日志文件不可写时返回未就绪
+func TestReadyzLogFile(t *testing.T) {
+	rec := newRecorder(t)
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "gateway.log")
+	path := filepath.Join(dir, "config.toml")
+	content := `
+[log]
+type = "file"
+path = "` + logPath + `"
+
+[silences]
+path = "` + filepath.Join(dir, "silences.json") + `"
+
+[[receivers]]
+name = "ops"
+type = "dingding"
+token = "token"
+webhook_url = "` + rec.URL + `/?"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	app, err := apps.NewApp(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	app.Start()
+	defer app.Stop()
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	// 日志文件还没有创建
+	if code, ready := getReadyz(t, gateway.URL); code != http.StatusServiceUnavailable || ready.Checks["log"] == "ok" {
+		t.Errorf("readyz = %d %+v without log file", code, ready)
+	}
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, ready := getReadyz(t, gateway.URL); code != http.StatusOK || ready.Checks["log"] != "ok" {
+		t.Errorf("readyz = %d %+v", code, ready)
+	}
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAlertHistory(t *testing.T) {
+	cfg := config.History{Path: filepath.Join(t.TempDir(), "history.db")}
+	history, err := apps.OpenHistory(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open history: %v", err)
+	}
+
+	startsAt := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	cpu := apps.Alert{
+		Status:   "firing",
+		Labels:   map[string]string{"alertname": "cpu_temperature_max", "instance": "10.10.1.21:8000"},
+		StartsAt: startsAt,
+	}
+	disk := apps.Alert{
+		Status:   "firing",
+		Labels:   map[string]string{"alertname": "disk_usage", "instance": "10.10.1.22:8000"},
+		StartsAt: startsAt,
+	}
+	deliveries := [][]apps.DeliveryRecord{
+		{{Receiver: "ops", Result: "success"}},
+		{{Result: "suppressed", Detail: "duplicate"}},
+	}
+	if err := history.Record([]apps.Alert{cpu, disk}, deliveries); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	// 恢复通知更新同一条记录
+	cpu.Status = "resolved"
+	cpu.EndsAt = time.Now().UTC().Format(time.RFC3339)
+	if err := history.Record([]apps.Alert{cpu}, nil); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := history.AddDelivery([]apps.Alert{cpu}, apps.DeliveryRecord{Receiver: "dba", Result: "failed", Detail: "timeout"}); err != nil {
+		t.Fatalf("AddDelivery() error = %v", err)
+	}
+
+	// 重新打开后记录仍然存在
+	history.Close()
+	if history, err = apps.OpenHistory(cfg); err != nil {
+		t.Fatalf("Failed to reopen history: %v", err)
+	}
+	defer history.Close()
+
+	matcher, _ := apps.ParseMatcher(`instance=~"10.10.1.21:.*"`)
+	page, err := history.Query(apps.HistoryQuery{Matchers: apps.Matchers{matcher}})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if page.Total != 1 || len(page.Alerts) != 1 {
+		t.Fatalf("Query() = %+v", page)
+	}
+	record := page.Alerts[0]
+	if record.Status != "resolved" || len(record.Transitions) != 2 || record.Received != 2 {
+		t.Errorf("status = %s, transitions = %v, received = %d", record.Status, record.Transitions, record.Received)
+	}
+	if len(record.Deliveries) != 2 || record.Deliveries[1].Detail != "timeout" {
+		t.Errorf("deliveries = %+v", record.Deliveries)
+	}
+
+	if page, _ = history.Query(apps.HistoryQuery{Status: "firing"}); page.Total != 1 || page.Alerts[0].Labels["alertname"] != "disk_usage" {
+		t.Errorf("firing alerts = %+v", page)
+	}
+	// 时间范围在报警开始之前
+	if page, _ = history.Query(apps.HistoryQuery{End: time.Now().Add(-2 * time.Hour)}); page.Total != 0 {
+		t.Errorf("alerts before start = %d", page.Total)
+	}
+	// 分页，最新的报警在前面
+	page, _ = history.Query(apps.HistoryQuery{Offset: 1, Limit: 1})
+	if page.Total != 2 || len(page.Alerts) != 1 || page.Alerts[0].Labels["alertname"] != "cpu_temperature_max" {
+		t.Errorf("second page = %+v", page)
+	}
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInhibitor(t *testing.T) {
+	rules := []config.InhibitRule{{
+		SourceMatch:   map[string]string{"alertname": "cpu_temperature_max"},
+		TargetMatchRE: map[string]string{"severity": "warning|info"},
+		Equal:         []string{"instance"},
+	}}
+	inhibitor, err := apps.NewInhibitor(rules, 0)
+	if err != nil {
+		t.Fatalf("NewInhibitor() error = %v", err)
+	}
+
+	source := apps.Alert{Status: "firing", Fingerprint: "source", Labels: map[string]string{
+		"alertname": "cpu_temperature_max", "instance": "10.10.1.21:8000", "severity": "warning",
+	}}
+	target := apps.Alert{Status: "firing", Fingerprint: "target", Labels: map[string]string{
+		"alertname": "node_load", "instance": "10.10.1.21:8000", "severity": "warning",
+	}}
+	other := apps.Alert{Status: "firing", Fingerprint: "other", Labels: map[string]string{
+		"alertname": "node_load", "instance": "10.10.1.22:8000", "severity": "warning",
+	}}
+
+	if got := inhibitor.Inhibits(target); got != "" {
+		t.Errorf("inhibited by %s before source fired", got)
+	}
+	inhibitor.Observe(source)
+	if got := inhibitor.Inhibits(target); got != "source" {
+		t.Errorf("Inhibits(target) = %q, want source", got)
+	}
+	// instance 不同的报警和源报警本身不被抑制
+	if got := inhibitor.Inhibits(other); got != "" {
+		t.Errorf("Inhibits(other) = %q", got)
+	}
+	if got := inhibitor.Inhibits(source); got != "" {
+		t.Errorf("source inhibited by %s", got)
+	}
+
+	source.Status = "resolved"
+	inhibitor.Observe(source)
+	if got := inhibitor.Inhibits(target); got != "" {
+		t.Errorf("inhibited by %s after source resolved", got)
+	}
+	if inhibitor.Sources() != 0 {
+		t.Errorf("Sources() = %d", inhibitor.Sources())
+	}
+}
+
+// 源报警的恢复通知丢失时，在 endsAt 之后停止抑制，没有 endsAt 时在 ttl 之后停止抑制
+func TestInhibitorExpiry(t *testing.T) {
+	rules := []config.InhibitRule{{
+		SourceMatch: map[string]string{"alertname": "node_down"},
+		TargetMatch: map[string]string{"severity": "warning"},
+		Equal:       []string{"instance"},
+	}}
+	inhibitor, err := apps.NewInhibitor(rules, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewInhibitor() error = %v", err)
+	}
+
+	// alertmanager webhook 中故障报警的 endsAt 为零值
+	noEnd := apps.Alert{Status: "firing", Fingerprint: "no_end", EndsAt: "0001-01-01T00:00:00Z", Labels: map[string]string{
+		"alertname": "node_down", "instance": "db01",
+	}}
+	withEnd := apps.Alert{Status: "firing", Fingerprint: "with_end", EndsAt: time.Now().Add(300 * time.Millisecond).Format(time.RFC3339Nano), Labels: map[string]string{
+		"alertname": "node_down", "instance": "db02",
+	}}
+	target := func(instance string) apps.Alert {
+		return apps.Alert{Status: "firing", Labels: map[string]string{"alertname": "disk_usage", "instance": instance, "severity": "warning"}}
+	}
+
+	inhibitor.Observe(noEnd)
+	inhibitor.Observe(withEnd)
+	if got := inhibitor.Inhibits(target("db01")); got != "no_end" {
+		t.Errorf("Inhibits(db01) = %q, want no_end", got)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if got := inhibitor.Inhibits(target("db01")); got != "" {
+		t.Errorf("inhibited by %s after ttl", got)
+	}
+	if got := inhibitor.Inhibits(target("db02")); got != "with_end" {
+		t.Errorf("Inhibits(db02) = %q before endsAt, want with_end", got)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got := inhibitor.Inhibits(target("db02")); got != "" {
+		t.Errorf("inhibited by %s after endsAt", got)
+	}
+	if inhibitor.Sources() != 0 {
+		t.Errorf("Sources() = %d", inhibitor.Sources())
+	}
+}
+
+// prometheus 每次推送的源报警带着新的 endsAt，状态没有变化也要延长抑制的时间
+func TestInhibitorV2Repeats(t *testing.T) {
+	rec := newRecorder(t)
+	app := newTestApp(t, `
+[[inhibit_rules]]
+source_match = { alertname = "HostDown" }
+target_match = { alertname = "HighCPU" }
+equal = ["instance"]
+
+[[receivers]]
+name = "hook"
+type = "webhook"
+webhook_url = "`+rec.URL+`"
+`)
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	// 每次推送的 endsAt 都在 1 秒之后，推送的间隔超过一半，第三次推送时第一次的 endsAt 已经过去
+	for i := 0; i < 4; i++ {
+		endsAt := time.Now().Add(time.Second).UTC().Format(time.RFC3339Nano)
+		body := `[{"labels": {"alertname": "HostDown", "instance": "db01"}, "endsAt": "` + endsAt + `"},
+			{"labels": {"alertname": "HighCPU", "instance": "db01"}, "endsAt": "` + endsAt + `"}]`
+		if code, response := postAlerts(t, gateway.URL+"/api/v2/alerts", body); code != http.StatusOK {
+			t.Fatalf("post %d: status = %d, response = %s", i+1, code, response)
+		}
+		time.Sleep(600 * time.Millisecond)
+	}
+	received := rec.received()
+	if len(received) != 1 || !strings.Contains(received[0], "HostDown") {
+		t.Errorf("received %d messages, want only HostDown: %v", len(received), received)
+	}
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// 初始化日志，日志级别为 error 避免测试输出过多
+	logger.InitLogger(testLogConfig())
+	os.Exit(m.Run())
+}
+
+// 测试使用的日志配置
+func testLogConfig() *config.Config {
+	cfg := config.NewConfig()
+	cfg.Log.Level = "error"
+	return cfg
+}
+
+// 按配置文件的内容创建网关，静默文件放在临时目录中
+func newTestApp(t *testing.T, content string) *apps.App {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	content = "[log]\nlevel = \"error\"\n\n[silences]\npath = \"" + filepath.Join(dir, "silences.json") + "\"\n" + content
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	app, err := apps.NewApp(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	return app
+}
+
+// 记录收到的请求的接收端，响应钉钉格式的成功结果
+type recorder struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func newRecorder(t *testing.T) *recorder {
+	rec := &recorder{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, string(body))
+		rec.mu.Unlock()
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// 收到的请求体
+func (rec *recorder) received() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string(nil), rec.bodies...)
+}
+
+// 第 i 个请求
+func (rec *recorder) request(i int) *http.Request {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.requests[i]
+}
+
+// 等待收到 n 个请求，超时返回 false
+func (rec *recorder) wait(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for len(rec.received()) < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return true
+}
+
+// 向网关 POST 报警，返回状态码和响应
+func postAlerts(t *testing.T, url, body string) (int, string) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// GET 请求，返回状态码和响应
+func getURL(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 监控指标是全局的，测试使用单独的接收者和报警名称
+func TestMetrics(t *testing.T) {
+	rec := newRecorder(t)
+	app := newTestApp(t, `
+[queue]
+path = "`+filepath.Join(t.TempDir(), "queue.db")+`"
+
+[[receivers]]
+name = "metrics_ops"
+type = "dingding"
+token = "token"
+webhook_url = "`+rec.URL+`/?"
+`)
+	defer app.Stop()
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	body := `{"status":"firing","alerts":[
+		{"status":"firing","labels":{"alertname":"metrics_test","instance":"db01"}},
+		{"status":"resolved","labels":{"alertname":"metrics_test","instance":"db02"}}]}`
+	if code, response := postAlerts(t, gateway.URL+"/", body); code != http.StatusOK {
+		t.Fatalf("status = %d, response = %s", code, response)
+	}
+
+	resp, err := http.Get(gateway.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	metrics, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`alert_gateway_alerts_received_total{alertname="metrics_test",status="firing"} 1`,
+		`alert_gateway_alerts_received_total{alertname="metrics_test",status="resolved"} 1`,
+		`alert_gateway_deliveries_total{receiver="metrics_ops",result="success"} 2`,
+		`alert_gateway_dingding_request_duration_seconds_count{receiver="metrics_ops"} 2`,
+		`alert_gateway_last_success_timestamp_seconds{receiver="metrics_ops"}`,
+		`alert_gateway_queue_depth{state="pending"} 0`,
+		`alert_gateway_webhooks_received_total`,
+	} {
+		if !strings.Contains(string(metrics), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
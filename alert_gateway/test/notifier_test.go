@@ -0,0 +1,175 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+// 发送一条消息，返回接收端收到的请求体
+func sendTo(t *testing.T, rec *recorder, receiver config.Receiver, msg *apps.Message) string {
+	notifier, err := apps.NewNotifier(receiver)
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	if _, err := notifier.Send(msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	bodies := rec.received()
+	return bodies[len(bodies)-1]
+}
+
+func TestWeComPayload(t *testing.T) {
+	rec := newRecorder(t)
+	receiver := config.Receiver{Name: "ops", Type: "wecom", WebhookURL: rec.URL + "/cgi-bin/webhook/send?key=", Token: "key01"}
+
+	var payload struct {
+		MsgType  string `json:"msgtype"`
+		Markdown struct {
+			Content string `json:"content"`
+		} `json:"markdown"`
+		Text struct {
+			Content string `json:"content"`
+		} `json:"text"`
+	}
+	body := sendTo(t, rec, receiver, &apps.Message{Title: "故障", Content: "**cpu**", Type: "markdown"})
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.MsgType != "markdown" || payload.Markdown.Content != "**cpu**" {
+		t.Errorf("payload = %s", body)
+	}
+	if key := rec.request(0).URL.Query().Get("key"); key != "key01" {
+		t.Errorf("key = %s, want key01", key)
+	}
+
+	body = sendTo(t, rec, receiver, &apps.Message{Title: "故障", Content: "cpu", Type: "text"})
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.MsgType != "text" || payload.Text.Content != "cpu" {
+		t.Errorf("payload = %s", body)
+	}
+}
+
+func TestFeishuSign(t *testing.T) {
+	rec := newRecorder(t)
+	receiver := config.Receiver{Name: "ops", Type: "feishu", WebhookURL: rec.URL + "/open-apis/bot/v2/hook/", Token: "hook01", Secret: "secret"}
+
+	var payload struct {
+		Timestamp string `json:"timestamp"`
+		Sign      string `json:"sign"`
+		MsgType   string `json:"msg_type"`
+		Card      struct {
+			Header struct {
+				Title struct {
+					Content string `json:"content"`
+				} `json:"title"`
+				Template string `json:"template"`
+			} `json:"header"`
+			Elements []struct {
+				Tag     string `json:"tag"`
+				Content string `json:"content"`
+			} `json:"elements"`
+		} `json:"card"`
+	}
+	body := sendTo(t, rec, receiver, &apps.Message{Title: "恢复", Content: "cpu", Type: "markdown", Status: "resolved"})
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if path := rec.request(0).URL.Path; path != "/open-apis/bot/v2/hook/hook01" {
+		t.Errorf("path = %s", path)
+	}
+	// 以 timestamp + "\n" + secret 为密钥对空字符串签名
+	h := hmac.New(sha256.New, []byte(payload.Timestamp+"\nsecret"))
+	if want := base64.StdEncoding.EncodeToString(h.Sum(nil)); payload.Timestamp == "" || payload.Sign != want {
+		t.Errorf("timestamp = %s, sign = %s, want %s", payload.Timestamp, payload.Sign, want)
+	}
+	if payload.MsgType != "interactive" || payload.Card.Header.Title.Content != "恢复" || payload.Card.Header.Template != "green" {
+		t.Errorf("payload = %s", body)
+	}
+	if len(payload.Card.Elements) != 1 || payload.Card.Elements[0].Content != "cpu" {
+		t.Errorf("elements = %+v", payload.Card.Elements)
+	}
+
+	// 没有配置 secret 时不签名
+	receiver.Secret = ""
+	body = sendTo(t, rec, receiver, &apps.Message{Title: "故障", Content: "cpu", Type: "text"})
+	var text map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &text); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if _, ok := text["sign"]; ok || text["msg_type"] != "text" {
+		t.Errorf("payload = %s", body)
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	rec := newRecorder(t)
+	receiver := config.Receiver{Name: "ops", Type: "slack", WebhookURL: rec.URL + "/services/T000/B000/XXX"}
+
+	var payload struct {
+		Text   string `json:"text"`
+		Blocks []struct {
+			Type string `json:"type"`
+			Text struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"text"`
+		} `json:"blocks"`
+	}
+	body := sendTo(t, rec, receiver, &apps.Message{Title: "故障", Content: "*cpu*", Type: "markdown"})
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Text != "故障" || len(payload.Blocks) != 1 {
+		t.Fatalf("payload = %s", body)
+	}
+	if block := payload.Blocks[0]; block.Type != "section" || block.Text.Type != "mrkdwn" || block.Text.Text != "*cpu*" {
+		t.Errorf("blocks[0] = %+v", block)
+	}
+
+	// 文本消息只有 text 字段
+	payload.Blocks = nil
+	body = sendTo(t, rec, receiver, &apps.Message{Title: "故障", Content: "cpu", Type: "text"})
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Text != "cpu" || len(payload.Blocks) != 0 {
+		t.Errorf("payload = %s", body)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rec := newRecorder(t)
+	receiver := config.Receiver{Name: "ops", Type: "webhook", WebhookURL: rec.URL + "/alerts", Secret: "secret",
+		Headers: map[string]config.Secret{"X-Team": "dba"}}
+
+	alerts := []apps.Alert{{Status: "firing", Labels: map[string]string{"alertname": "disk_usage", "instance": "db01"}}}
+	body := sendTo(t, rec, receiver, &apps.Message{Title: "故障", Content: "disk", Type: "markdown", Status: "firing", Alerts: alerts})
+	var payload apps.WebhookMessage
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Receiver != "ops" || payload.Status != "firing" || payload.Title != "故障" || payload.Message != "disk" {
+		t.Errorf("payload = %s", body)
+	}
+	if len(payload.Alerts) != 1 || payload.Alerts[0].Labels["instance"] != "db01" {
+		t.Errorf("alerts = %+v", payload.Alerts)
+	}
+
+	request := rec.request(0)
+	h := hmac.New(sha256.New, []byte("secret"))
+	h.Write([]byte(body))
+	if got, want := request.Header.Get("X-Alert-Gateway-Signature"), hex.EncodeToString(h.Sum(nil)); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if got := request.Header.Get("X-Team"); got != "dba" {
+		t.Errorf("X-Team = %s, want dba", got)
+	}
+}
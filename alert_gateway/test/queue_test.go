@@ -0,0 +1,105 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryQueueDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	cfg := config.Queue{Path: path, MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	attempts := 0
+	send := func(entry *apps.QueueEntry) error {
+		attempts++
+		return errors.New("dingding unavailable")
+	}
+	queue, err := apps.OpenRetryQueue(cfg, send)
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+
+	msg := &apps.Message{Title: "故障", Content: "test", Type: "text", Status: "firing"}
+	if _, err := queue.Enqueue("ops", msg, errors.New("timeout")); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	stop := make(chan struct{})
+	go queue.Run(stop)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, dead := queue.Depth(); dead == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message was not dead-lettered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(stop)
+	if attempts != 1 {
+		t.Errorf("expected 1 retry before dead-lettering, got %d", attempts)
+	}
+
+	// 重启后死信仍然存在，并且可以重新投递
+	queue.Close()
+	queue, err = apps.OpenRetryQueue(cfg, send)
+	if err != nil {
+		t.Fatalf("Failed to reopen queue: %v", err)
+	}
+	defer queue.Close()
+	dead, err := queue.List("dead")
+	if err != nil || len(dead) != 1 || dead[0].Message.Content != "test" {
+		t.Fatalf("unexpected dead letters %v: %v", dead, err)
+	}
+	if count, err := queue.Replay(0); err != nil || count != 1 {
+		t.Fatalf("Replay = %d, %v", count, err)
+	}
+	if pending, dead := queue.Depth(); pending != 1 || dead != 0 {
+		t.Errorf("Depth = %d, %d after replay", pending, dead)
+	}
+}
+
+// 直接放入死信的消息没有送达，返回 502
+func TestPermanentErrorDeadLetter(t *testing.T) {
+	dingtalk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errcode":310000,"errmsg":"keywords not in content"}`))
+	}))
+	defer dingtalk.Close()
+	queuePath := filepath.Join(t.TempDir(), "queue.db")
+	app := newTestApp(t, `
+[queue]
+path = "`+queuePath+`"
+
+[[receivers]]
+name = "ops"
+type = "dingding"
+token = "token"
+webhook_url = "`+dingtalk.URL+`/?"
+`)
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	body := `{"status":"firing","alerts":[{"status":"firing","labels":{"alertname":"disk_usage","instance":"db01"}}]}`
+	code, response := postAlerts(t, gateway.URL+"/", body)
+	if code != http.StatusBadGateway || !strings.Contains(response, `"dead":true`) {
+		t.Errorf("status = %d, response = %s", code, response)
+	}
+	app.Stop()
+
+	queue, err := apps.OpenRetryQueue(config.Queue{Path: queuePath}, func(*apps.QueueEntry) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	defer queue.Close()
+	if pending, dead := queue.Depth(); pending != 0 || dead != 1 {
+		t.Errorf("pending = %d, dead = %d, want 0 and 1", pending, dead)
+	}
+}
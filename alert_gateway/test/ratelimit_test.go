@@ -0,0 +1,139 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 四条报警，每条报警发送一条消息
+const fourAlerts = `{"status":"firing","alerts":[
+	{"status":"firing","labels":{"alertname":"disk_usage","instance":"db01"}},
+	{"status":"firing","labels":{"alertname":"disk_usage","instance":"db02"}},
+	{"status":"firing","labels":{"alertname":"disk_usage","instance":"db03"}},
+	{"status":"firing","labels":{"alertname":"disk_usage","instance":"db04"}}]}`
+
+func rateLimitStats(t *testing.T, url string) []apps.RateLimitStats {
+	resp, err := http.Get(url + "/api/ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats []apps.RateLimitStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+// 令牌用完后消息被保存，令牌补充后合并成一条汇总消息发送
+func TestRateLimitDigest(t *testing.T) {
+	rec := newRecorder(t)
+	app := newTestApp(t, `
+[[receivers]]
+name = "hook"
+type = "webhook"
+webhook_url = "`+rec.URL+`"
+rate_limit = 60
+rate_burst = 1
+`)
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	if code, body := postAlerts(t, gateway.URL+"/", fourAlerts); code != http.StatusOK || strings.Count(body, `"held":true`) != 3 {
+		t.Fatalf("status = %d, response = %s", code, body)
+	}
+	if stats := rateLimitStats(t, gateway.URL); len(stats) != 1 || stats[0].Held != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// 每秒补充一个令牌
+	app.Start()
+	defer app.Stop()
+	if !rec.wait(2, 3*time.Second) {
+		t.Fatalf("received %d messages, want 2", len(rec.received()))
+	}
+	if digest := rec.received()[1]; !strings.Contains(digest, "报警汇总(3)") {
+		t.Errorf("digest = %s", digest)
+	}
+	if stats := rateLimitStats(t, gateway.URL); stats[0].Held != 0 || stats[0].Digests != 1 || stats[0].Merged != 3 {
+		t.Errorf("stats after flush = %+v", stats)
+	}
+}
+
+// 合并后超过长度限制时剩下的消息留给下一条汇总消息
+func TestRateLimitDigestSize(t *testing.T) {
+	rec := newRecorder(t)
+	// 一条报警的消息约 120 字节，400 字节只能合并两条
+	app := newTestApp(t, `
+[[receivers]]
+name = "hook"
+type = "webhook"
+webhook_url = "`+rec.URL+`"
+rate_limit = 60
+rate_burst = 1
+max_message_size = 400
+`)
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	postAlerts(t, gateway.URL+"/", fourAlerts)
+	app.Start()
+	defer app.Stop()
+	if !rec.wait(3, 4*time.Second) {
+		t.Fatalf("received %d messages, want 3", len(rec.received()))
+	}
+	received := rec.received()
+	if !strings.Contains(received[1], "报警汇总(2)") {
+		t.Errorf("first digest = %s", received[1])
+	}
+	if strings.Contains(received[2], "报警汇总") || !strings.Contains(received[2], "db04") {
+		t.Errorf("last message = %s", received[2])
+	}
+}
+
+// 重新加载时保存的消息移到新的接收者，退出时放入重试队列
+func TestRateLimitDrain(t *testing.T) {
+	rec := newRecorder(t)
+	queuePath := filepath.Join(t.TempDir(), "queue.db")
+	app := newTestApp(t, `
+[queue]
+path = "`+queuePath+`"
+
+[[receivers]]
+name = "hook"
+type = "webhook"
+webhook_url = "`+rec.URL+`"
+rate_limit = 60
+rate_burst = 1
+`)
+	gateway := httptest.NewServer(app.Handler())
+	defer gateway.Close()
+
+	postAlerts(t, gateway.URL+"/", fourAlerts)
+	if err := app.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if stats := rateLimitStats(t, gateway.URL); stats[0].Held != 3 {
+		t.Fatalf("held after reload = %d, want 3", stats[0].Held)
+	}
+
+	app.Stop()
+	queue, err := apps.OpenRetryQueue(config.Queue{Path: queuePath}, func(*apps.QueueEntry) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	defer queue.Close()
+	if pending, _ := queue.Depth(); pending != 3 {
+		t.Errorf("queued messages = %d, want 3", pending)
+	}
+	if n := len(rec.received()); n != 1 {
+		t.Errorf("received %d messages, want 1", n)
+	}
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"alert_gateway/logger"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	valid := `
+[app]
+listen = "127.0.0.1"
+port = 5000
+
+[log]
+level = "error"
+
+[silences]
+path = "` + filepath.Join(dir, "silences.json") + `"
+
+[[receivers]]
+name = "ops"
+type = "dingding"
+token = "token"
+`
+	if err := os.WriteFile(path, []byte(valid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	app, err := apps.NewApp(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	// 路由到不存在的接收者时重新加载失败
+	invalid := valid + `
+[route]
+receiver = "dba"
+`
+	os.WriteFile(path, []byte(invalid), 0644)
+	if err := app.Reload(); err == nil {
+		t.Error("Reload() with unknown receiver succeeded")
+	}
+
+	os.WriteFile(path, []byte(valid+`
+[[receivers]]
+name = "dba"
+type = "dingding"
+token = "token2"
+
+[route]
+receiver = "dba"
+`), 0644)
+	if err := app.Reload(); err != nil {
+		t.Errorf("Reload() error = %v", err)
+	}
+}
+
+// 重新加载时替换日志文件，旧的文件描述符被关闭，重新加载期间可以同时写日志
+func TestReloadLogFile(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("/proc/self/fd not available")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	logPath := filepath.Join(dir, "gateway.log")
+	os.WriteFile(path, []byte(`
+[log]
+level = "info"
+type = "file"
+path = "`+logPath+`"
+
+[silences]
+path = "`+filepath.Join(dir, "silences.json")+`"
+
+[[receivers]]
+name = "ops"
+type = "dingding"
+token = "token"
+`), 0644)
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	logger.InitLogger(cfg)
+	defer logger.InitLogger(testLogConfig())
+	app, err := apps.NewApp(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				logger.Infof("logging during reload")
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if err := app.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+	}
+	close(stop)
+	<-done
+
+	entries, _ := os.ReadDir("/proc/self/fd")
+	open := 0
+	for _, entry := range entries {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", entry.Name())); err == nil && target == logPath {
+			open++
+		}
+	}
+	if open != 1 {
+		t.Errorf("%d open descriptors of the log file, want 1", open)
+	}
+}
@@ -0,0 +1,38 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"reflect"
+	"testing"
+)
+
+func TestRouteReceivers(t *testing.T) {
+	cfg := &config.Route{
+		Receiver: "default",
+		Routes: []*config.Route{
+			{Receiver: "oncall", Match: map[string]string{"severity": "critical"}, Continue: true},
+			{Receiver: "hardware", MatchRE: map[string]string{"job": "cpu.*"}},
+			{Receiver: "never", Match: map[string]string{"job": "cpu temperature"}},
+		},
+	}
+	route, err := apps.NewRoute(cfg, "default")
+	if err != nil {
+		t.Fatalf("Failed to build route: %v", err)
+	}
+
+	tests := []struct {
+		labels map[string]string
+		want   []string
+	}{
+		{map[string]string{"severity": "critical", "job": "cpu temperature"}, []string{"oncall", "hardware"}},
+		{map[string]string{"severity": "warning", "job": "cpu temperature"}, []string{"hardware"}},
+		{map[string]string{"severity": "critical", "job": "node"}, []string{"oncall"}},
+		{map[string]string{"job": "xcpu"}, []string{"default"}},
+	}
+	for _, tt := range tests {
+		if got := route.Receivers(tt.labels); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Receivers(%v) = %v, want %v", tt.labels, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	location, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, location)
+	}
+	schedule, err := apps.NewSchedule(config.Schedule{
+		Name:     "ops",
+		Timezone: "Asia/Shanghai",
+		Start:    "2024-01-01",
+		Handoff:  "09:00",
+		Members: []config.ScheduleMember{
+			{Name: "zhangsan", Mobile: "13800000001"},
+			{Name: "lisi", UserID: "lisi01"},
+			{Name: "wangwu", Mobile: "13800000003"},
+		},
+		Overrides: []config.ScheduleOverride{{Member: "lisi", Start: at(3, 12, 0), End: at(4, 12, 0)}},
+	})
+	if err != nil {
+		t.Fatalf("NewSchedule() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		time time.Time
+		want string
+	}{
+		{at(1, 8, 59), "wangwu"}, // 开始之前轮换到最后一个人
+		{at(1, 9, 0), "zhangsan"},
+		{at(3, 13, 0), "lisi"}, // 临时替班
+		{at(8, 8, 59), "zhangsan"},
+		{at(8, 9, 0), "lisi"},
+		{at(22, 10, 0), "zhangsan"},
+	} {
+		if got := schedule.OnCall(tt.time).Member; got != tt.want {
+			t.Errorf("OnCall(%s) = %s, want %s", tt.time, got, tt.want)
+		}
+	}
+
+	shifts := schedule.Shifts(at(2, 0, 0), at(9, 0, 0))
+	want := []struct {
+		member     string
+		start, end time.Time
+		override   bool
+	}{
+		{"zhangsan", at(1, 9, 0), at(3, 12, 0), false},
+		{"lisi", at(3, 12, 0), at(4, 12, 0), true},
+		{"zhangsan", at(4, 12, 0), at(8, 9, 0), false},
+		{"lisi", at(8, 9, 0), at(15, 9, 0), false},
+	}
+	if len(shifts) != len(want) {
+		t.Fatalf("Shifts() = %+v", shifts)
+	}
+	for i, w := range want {
+		got := shifts[i]
+		if got.Member != w.member || !got.Start.Equal(w.start) || !got.End.Equal(w.end) || got.Override != w.override {
+			t.Errorf("shifts[%d] = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+// 夏令时切换当天零点到交接时间不是 9 小时，按当地时间交接
+func TestScheduleDST(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, location)
+	}
+	members := []config.ScheduleMember{{Name: "zhangsan"}, {Name: "lisi"}}
+
+	for _, tt := range []struct {
+		rotation string
+		time     time.Time
+		want     string
+		start    time.Time
+	}{
+		// 2026-03-29 开始夏令时，2026-10-25 结束夏令时
+		{"daily", at(3, 29, 8, 30), "lisi", at(3, 28, 9, 0)},
+		{"daily", at(3, 29, 9, 30), "zhangsan", at(3, 29, 9, 0)},
+		{"daily", at(10, 25, 8, 30), "lisi", at(10, 24, 9, 0)},
+		{"daily", at(10, 25, 9, 30), "zhangsan", at(10, 25, 9, 0)},
+		// 周轮换从 2026-03-01（周日）开始，交接在周日
+		{"weekly", at(3, 29, 8, 30), "lisi", at(3, 22, 9, 0)},
+		{"weekly", at(3, 29, 9, 30), "zhangsan", at(3, 29, 9, 0)},
+		{"weekly", at(10, 25, 8, 30), "lisi", at(10, 18, 9, 0)},
+		{"weekly", at(10, 25, 9, 30), "zhangsan", at(10, 25, 9, 0)},
+	} {
+		schedule, err := apps.NewSchedule(config.Schedule{
+			Name:     "ops",
+			Timezone: "Europe/Berlin",
+			Rotation: tt.rotation,
+			Start:    "2026-03-01",
+			Handoff:  "09:00",
+			Members:  members,
+		})
+		if err != nil {
+			t.Fatalf("NewSchedule() error = %v", err)
+		}
+		shift := schedule.OnCall(tt.time)
+		if shift.Member != tt.want || !shift.Start.Equal(tt.start) {
+			t.Errorf("%s OnCall(%s) = %s from %s, want %s from %s", tt.rotation, tt.time, shift.Member, shift.Start, tt.want, tt.start)
+		}
+		if shifts := schedule.Shifts(tt.time, tt.time.AddDate(0, 0, 14)); len(shifts) == 0 || shifts[0].Member != tt.want {
+			t.Errorf("%s Shifts(%s) = %+v", tt.rotation, tt.time, shifts)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 收到请求后等待 delay 再响应的接收端，started 在收到第一个请求时关闭
+func newSlowReceiver(t *testing.T, delay time.Duration) (*httptest.Server, <-chan struct{}) {
+	started := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(started) })
+		time.Sleep(delay)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, started
+}
+
+// 在随机端口上启动网关
+func serveGateway(t *testing.T, app *apps.App) (*http.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: app.Handler()}
+	go server.Serve(listener)
+	app.Start()
+	return server, "http://" + listener.Addr().String()
+}
+
+// 退出时等待正在发送的报警完成，之后不再接收新的请求
+func TestShutdownDrainsInflight(t *testing.T) {
+	receiver, started := newSlowReceiver(t, 300*time.Millisecond)
+	app := newTestApp(t, `
+[[receivers]]
+name = "ops"
+type = "dingding"
+token = "token"
+webhook_url = "`+receiver.URL+`/?"
+`)
+	server, url := serveGateway(t, app)
+	if code, _ := getURL(t, url+"/readyz"); code != http.StatusOK {
+		t.Fatalf("readyz = %d before shutdown", code)
+	}
+
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(url+"/", "application/json", strings.NewReader(`{"status":"firing","alerts":[{"status":"firing","labels":{"alertname":"disk_usage","instance":"db01"}}]}`))
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	<-started
+	app.Shutdown(server)
+
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("in-flight request status = %d, want 200", code)
+	}
+	if _, err := http.Get(url + "/healthz"); err == nil {
+		t.Error("server still accepting requests after shutdown")
+	}
+	response := httptest.NewRecorder()
+	app.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if response.Code != http.StatusServiceUnavailable || !strings.Contains(response.Body.String(), "shutting down") {
+		t.Errorf("readyz = %d %s after shutdown", response.Code, response.Body.String())
+	}
+}
+
+// 超过 shutdown_timeout 后还没有发送的消息放入重试队列
+func TestShutdownTimeoutQueues(t *testing.T) {
+	receiver, started := newSlowReceiver(t, 500*time.Millisecond)
+	queuePath := filepath.Join(t.TempDir(), "queue.db")
+	app := newTestApp(t, `
+[app]
+shutdown_timeout = "100ms"
+
+[queue]
+path = "`+queuePath+`"
+
+[[receivers]]
+name = "ops"
+type = "dingding"
+token = "token"
+webhook_url = "`+receiver.URL+`/?"
+`)
+	server, url := serveGateway(t, app)
+
+	// 每条报警一条消息，第一条发送时退出超时，第二条放入重试队列
+	go http.Post(url+"/", "application/json", strings.NewReader(`{"status":"firing","alerts":[
+		{"status":"firing","labels":{"alertname":"disk_usage","instance":"db01"}},
+		{"status":"firing","labels":{"alertname":"disk_usage","instance":"db02"}}]}`))
+	<-started
+	app.Shutdown(server)
+
+	queue, err := apps.OpenRetryQueue(config.Queue{Path: queuePath}, func(*apps.QueueEntry) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to open queue: %v", err)
+	}
+	defer queue.Close()
+	if pending, dead := queue.Depth(); pending != 1 || dead != 0 {
+		t.Errorf("pending = %d, dead = %d, want 1 and 0", pending, dead)
+	}
+}
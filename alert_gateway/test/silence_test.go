@@ -0,0 +1,112 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSilences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "silences.json")
+	silences, err := apps.LoadSilences(path)
+	if err != nil {
+		t.Fatalf("Failed to load silences: %v", err)
+	}
+
+	var silence apps.Silence
+	body := `{"matchers":[{"name":"instance","value":"10.10.1.21:.*","isRegex":true}],"endsAt":"` +
+		time.Now().Add(time.Hour).Format(time.RFC3339) + `","createdBy":"ops","comment":"维护"}`
+	if err := json.Unmarshal([]byte(body), &silence); err != nil {
+		t.Fatalf("Failed to parse silence: %v", err)
+	}
+	if err := silences.Create(&silence); err != nil {
+		t.Fatalf("Failed to create silence: %v", err)
+	}
+
+	labels := map[string]string{"alertname": "cpu_temperature_max", "instance": "10.10.1.21:8000"}
+	if id := silences.Mutes(labels); id != silence.ID {
+		t.Errorf("Mutes() = %q, want %q", id, silence.ID)
+	}
+	if id := silences.Mutes(map[string]string{"instance": "10.10.1.22:8000"}); id != "" {
+		t.Errorf("unexpected silence %s", id)
+	}
+
+	// 重新加载后静默仍然生效
+	reloaded, err := apps.LoadSilences(path)
+	if err != nil {
+		t.Fatalf("Failed to reload silences: %v", err)
+	}
+	if id := reloaded.Mutes(labels); id != silence.ID {
+		t.Errorf("reloaded Mutes() = %q, want %q", id, silence.ID)
+	}
+	if err := reloaded.Expire(silence.ID); err != nil {
+		t.Fatalf("Failed to expire silence: %v", err)
+	}
+	if id := reloaded.Mutes(labels); id != "" {
+		t.Errorf("expired silence %s still mutes", id)
+	}
+	if list := reloaded.List("expired"); len(list) != 1 || list[0].Suppressed != 1 {
+		t.Errorf("unexpected expired silences %+v", list)
+	}
+}
+
+func TestParseMatcher(t *testing.T) {
+	tests := []struct {
+		matcher string
+		labels  map[string]string
+		want    bool
+	}{
+		{`severity="critical"`, map[string]string{"severity": "critical"}, true},
+		{`severity!=critical`, map[string]string{"severity": "critical"}, false},
+		{`job=~"cpu.*"`, map[string]string{"job": "cpu temperature"}, true},
+		{`job!~cpu.*`, map[string]string{"job": "node"}, true},
+	}
+	for _, tt := range tests {
+		m, err := apps.ParseMatcher(tt.matcher)
+		if err != nil {
+			t.Fatalf("ParseMatcher(%s): %v", tt.matcher, err)
+		}
+		if got := m.Matches(tt.labels); got != tt.want {
+			t.Errorf("%s.Matches(%v) = %v, want %v", tt.matcher, tt.labels, got, tt.want)
+		}
+	}
+}
+
+// 保存失败时静默保持原来的状态
+func TestSilenceExpireSaveFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "silences.json")
+	silences, err := apps.LoadSilences(path)
+	if err != nil {
+		t.Fatalf("Failed to load silences: %v", err)
+	}
+	matcher, err := apps.ParseMatcher("instance=db01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	silence := apps.Silence{
+		Matchers:  apps.Matchers{matcher},
+		EndsAt:    time.Now().Add(time.Hour),
+		CreatedBy: "ops",
+		Comment:   "维护",
+	}
+	if err := silences.Create(&silence); err != nil {
+		t.Fatalf("Failed to create silence: %v", err)
+	}
+
+	// 临时文件的位置被目录占用，写入失败
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := silences.Expire(silence.ID); err == nil {
+		t.Fatal("Expire() succeeded without saving")
+	}
+	if id := silences.Mutes(map[string]string{"instance": "db01"}); id != silence.ID {
+		t.Errorf("Mutes() = %q after failed expire, want %q", id, silence.ID)
+	}
+	if list := silences.List("active"); len(list) != 1 || !list[0].EndsAt.Equal(silence.EndsAt) {
+		t.Errorf("unexpected active silences %+v", list)
+	}
+}
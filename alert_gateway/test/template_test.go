@@ -0,0 +1,128 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadAlertData(t *testing.T) apps.AlertData {
+	body, err := os.ReadFile("test.json")
+	if err != nil {
+		t.Fatalf("Failed to read test.json: %v", err)
+	}
+	var alertData apps.AlertData
+	if err := json.Unmarshal(body, &alertData); err != nil {
+		t.Fatalf("Failed to parse test.json: %v", err)
+	}
+	return alertData
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	templates, err := apps.LoadTemplates(config.Templates{Timezone: "Asia/Shanghai"})
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+
+	data := &apps.TemplateData{AlertData: loadAlertData(t), Title: "恢复"}
+	content, err := templates.Render("default.markdown", data)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	want := "# <font color=#00FF00>恢复</font>\n\n" +
+		"- summary: Instance 10.10.1.21:8000 cpu核心最高温度[51].\n" +
+		"- alertname: cpu_temperature_max\n" +
+		"- alias: temperature\n" +
+		"- instance: 10.10.1.21:8000\n" +
+		"- job: cpu temperature\n" +
+		"- severity: critical\n" +
+		"- StartsAt: 2023-02-17 09:51:02\n"
+	if content != want {
+		t.Errorf("unexpected markdown:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestUserTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom.tmpl")
+	tmpl := `{{ define "custom" }}{{ .GroupLabels.alertname | toUpper }} {{ humanizeDuration (duration (index .Alerts 0).StartsAt (index .Alerts 0).EndsAt) }}{{ end }}`
+	if err := os.WriteFile(path, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := apps.LoadTemplates(config.Templates{Files: []string{path}, Markdown: "custom"})
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+	content, err := templates.Render("custom", &apps.TemplateData{AlertData: loadAlertData(t)})
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if content != "CPU_TEMPERATURE_MAX 30s" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestInvalidTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.tmpl")
+	if err := os.WriteFile(path, []byte(`{{ define "broken" }}{{ .Alerts `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := apps.LoadTemplates(config.Templates{Files: []string{path}})
+	if err == nil || !strings.Contains(err.Error(), "broken.tmpl") {
+		t.Errorf("expected parse error for broken.tmpl, got %v", err)
+	}
+}
+
+func TestGroupTemplate(t *testing.T) {
+	templates, err := apps.LoadTemplates(config.Templates{Timezone: "Asia/Shanghai"})
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+
+	alertData := loadAlertData(t)
+	firing := alertData.Alerts[0]
+	firing.Status = "firing"
+	firing.Labels = map[string]string{"alertname": "cpu_temperature_max", "instance": "10.10.1.22:8000"}
+	alertData.Alerts = append([]apps.Alert{firing}, alertData.Alerts...)
+
+	data := &apps.TemplateData{AlertData: alertData, Title: "故障", Truncated: 3}
+	content, err := templates.Render("default.group.text", data)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	for _, want := range []string{"故障 1:\ncpu_temperature_max 10.10.1.22:8000", "恢复 1:\ncpu_temperature_max 10.10.1.21:8000", "+3 more"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("content %q does not contain %q", content, want)
+		}
+	}
+}
+
+func TestJoinLabelValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "join.tmpl")
+	tmpl := `{{ define "join" }}{{ .LabelValues "instance" | join ", " }}{{ end }}`
+	if err := os.WriteFile(path, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := apps.LoadTemplates(config.Templates{Files: []string{path}})
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+
+	data := &apps.TemplateData{AlertData: apps.AlertData{Alerts: []apps.Alert{
+		{Labels: map[string]string{"instance": "db01"}},
+		{Labels: map[string]string{"instance": "db02"}},
+		{Labels: map[string]string{"instance": "db01"}},
+		{Labels: map[string]string{"alertname": "backup_failed"}},
+	}}}
+	content, err := templates.Render("join", data)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if content != "db01, db02" {
+		t.Errorf("content = %q, want %q", content, "db01, db02")
+	}
+}
@@ -0,0 +1,132 @@
+package test
+
+import (
+	"alert_gateway/apps"
+	"alert_gateway/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 生成证书，parent 为空时生成自签名的 CA 证书
+func generateCert(t *testing.T, cn string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caPEM, _ := generateCert(t, "ca", 1, nil, nil)
+	_, _, serverPEM, serverKeyPEM := generateCert(t, "server", 2, ca, caKey)
+	_, _, clientPEM, clientKeyPEM := generateCert(t, "client", 3, ca, caKey)
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	os.WriteFile(certFile, serverPEM, 0600)
+	os.WriteFile(keyFile, serverKeyPEM, 0600)
+	os.WriteFile(caFile, caPEM, 0600)
+
+	tlsConfig, err := apps.NewTLSConfig(config.App{
+		TLSCertFile:     certFile,
+		TLSKeyFile:      keyFile,
+		TLSClientCAFile: caFile,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create tls config: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	clientCert, _ := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	get := func(certs []tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs},
+		}}
+		return client.Get(server.URL)
+	}
+
+	// 提供客户端证书时可以访问，并且使用文件中的服务端证书
+	resp, err := get([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("request with client certificate failed: %v", err)
+	}
+	resp.Body.Close()
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Errorf("server certificate serial = %d, want 2", serial)
+	}
+
+	// 没有客户端证书时握手失败
+	if resp, err := get(nil); err == nil {
+		resp.Body.Close()
+		t.Error("request without client certificate succeeded")
+	}
+
+	// 明文请求被拒绝
+	resp, err = http.Get(strings.Replace(server.URL, "https://", "http://", 1))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("plaintext request status = %d, want 400", resp.StatusCode)
+		}
+	}
+
+	// 更新证书文件后不需要重启就使用新的证书
+	_, _, newPEM, newKeyPEM := generateCert(t, "server", 4, ca, caKey)
+	os.WriteFile(certFile, newPEM, 0600)
+	os.WriteFile(keyFile, newKeyPEM, 0600)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+
+	resp, err = get([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("request after reload failed: %v", err)
+	}
+	resp.Body.Close()
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+		t.Errorf("server certificate serial after reload = %d, want 4", serial)
+	}
+}